@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kkentzo/tagger/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTokenFilePath returns the token file that is kept next to the
+// state file at stateFilePath
+func DefaultTokenFilePath(stateFilePath string) string {
+	return filepath.Join(filepath.Dir(stateFilePath), "token")
+}
+
+// RequiresToken returns true if the api must be protected by a token
+func (config *Config) RequiresToken() bool {
+	return !config.Auth.Disabled && config.Listener().TCP != ""
+}
+
+// TokenFile returns the configured token file or else the one next to
+// the state file in effect (see -s)
+func (config *Config) TokenFile() string {
+	if config.Auth.TokenFile == "" {
+		if config.stateFilePath == "" {
+			return DefaultTokenFilePath(DefaultStateFilePath())
+		}
+		return DefaultTokenFilePath(config.stateFilePath)
+	}
+	return utils.Canonicalize(config.Auth.TokenFile)
+}
+
+// Token returns the configured token or the contents of the token file;
+// the token file is created with a random token if it does not exist
+func (config *Config) Token() (string, error) {
+	if config.Auth.Token != "" {
+		return config.Auth.Token, nil
+	}
+	path := config.TokenFile()
+	token, err := ReadToken(path)
+	if err == nil {
+		return token, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token = hex.EncodeToString(buf)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := utils.WriteFileAtomic(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	log.Info("Generated api token in ", path)
+	return token, nil
+}
+
+// ReadToken reads the token stored in path
+func ReadToken(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		log.Warnf("Token file %s is accessible by other users", path)
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// requireToken rejects the requests that do not carry the bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actual := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(actual, expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tagger"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kkentzo/tagger/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Config_Token_IsGeneratedOnFirstRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	config := &Config{Auth: Auth{TokenFile: filepath.Join(dir, "tagger", "token")}}
+
+	token, err := config.Token()
+	assert.Nil(t, err)
+	assert.Len(t, token, 64)
+	info, err := os.Stat(config.TokenFile())
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	again, err := config.Token()
+	assert.Nil(t, err)
+	assert.Equal(t, token, again)
+
+	config.Auth.Token = "secret"
+	token, err = config.Token()
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", token)
+}
+
+func Test_Config_TokenFile_IsKeptNextToStateFile(t *testing.T) {
+	config := &Config{}
+	assert.Equal(t, DefaultTokenFilePath(DefaultStateFilePath()), config.TokenFile())
+	config.stateFilePath = "/foo/bar/state.yml"
+	assert.Equal(t, "/foo/bar/token", config.TokenFile())
+	config.Auth.TokenFile = "/foo/token"
+	assert.Equal(t, "/foo/token", config.TokenFile())
+}
+
+func Test_Config_RequiresToken_OnlyForTcp(t *testing.T) {
+	assert.False(t, (&Config{}).RequiresToken())
+	assert.True(t, (&Config{Listen: Listen{TCP: "1234"}}).RequiresToken())
+	assert.False(t, (&Config{Listen: Listen{TCP: "1234"}, Auth: Auth{Disabled: true}}).RequiresToken())
+}
+
+func Test_Server_RejectsRequests_WithoutToken(t *testing.T) {
+	server := CreateTestServer()
+	server.Token = "secret"
+
+	w := Request(server, "GET", "/api/v1/projects", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="tagger"`, w.Header().Get("WWW-Authenticate"))
+
+	r, _ := http.NewRequest("GET", "/projects", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	w = Serve(server, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r, _ = http.NewRequest("GET", "/api/v1/projects", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w = Serve(server, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func Test_daemon_ReturnsConfigError_WhenTheTokenCanNotBeSetUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	// the token file can not be created under a regular file
+	TouchFile(t, filepath.Join(dir, "file")).Close()
+	config := filepath.Join(dir, "tagger.yml")
+	WriteConfig(t, config, "listen:\n  socket: "+filepath.Join(dir, "tagger.sock")+"\n  tcp: 127.0.0.1:0\n"+
+		"auth:\n  token_file: "+filepath.Join(dir, "file", "token")+"\n"+
+		"indexer:\n  program: ctags\n  tag_file: TAGS\n  max_period: 1s\n")
+
+	assert.Equal(t, ExitConfigError, daemon(config, filepath.Join(dir, "state.yml"), time.Second, false))
+	assert.False(t, utils.FileExists(filepath.Join(dir, "tagger.sock")))
+}
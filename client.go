@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+)
+
+// ApiError is an error response of the api
+type ApiError struct {
+	Status  int
+	Message string
+}
+
+func (e *ApiError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Status)
+}
+
+// Client talks to the api of a running tagger instance
+type Client struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+// NewClient connects through the unix socket of config if it exists and
+// through its tcp address otherwise; the api token (if required) is read
+// from the config or the token file. A socket that belongs to another
+// user is never used.
+func NewClient(config *Config) (*Client, error) {
+	listen := config.Listener()
+	client := &Client{http: &http.Client{}}
+	if info, err := os.Lstat(listen.Socket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 || !ownedByUser(info) {
+			return nil, fmt.Errorf("%s is not a socket of the current user", listen.Socket)
+		}
+		client.url = "http://tagger"
+		client.http.Transport = &http.Transport{
+			Dial: func(network, address string) (net.Conn, error) {
+				return net.Dial("unix", listen.Socket)
+			},
+		}
+	} else if listen.TCP != "" {
+		client.url = "http://" + listen.TCP
+	} else {
+		return nil, fmt.Errorf("tagger does not seem to be running (%s does not exist)", listen.Socket)
+	}
+	if config.RequiresToken() {
+		client.token = config.Auth.Token
+		if client.token == "" {
+			token, err := ReadToken(config.TokenFile())
+			if err != nil {
+				return nil, fmt.Errorf("Unable to read the api token: %s", err)
+			}
+			client.token = token
+		}
+	}
+	return client, nil
+}
+
+// Do sends a request (with body encoded as JSON unless nil) to path
+// under ApiPrefix and decodes the response into result (unless nil);
+// error responses are returned as *ApiError
+func (client *Client) Do(method string, path string, body interface{}, result interface{}) error {
+	resp, err := client.Request(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Request is like Do but returns the (successful) response, whose body
+// must be closed by the caller
+func (client *Client) Request(method string, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		contents, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(contents)
+	}
+	req, err := http.NewRequest(method, client.url+ApiPrefix+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if client.token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.token)
+	}
+	resp, err := client.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var e struct{ Error string }
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			e.Error = http.StatusText(resp.StatusCode)
+		}
+		return nil, &ApiError{Status: resp.StatusCode, Message: e.Error}
+	}
+	return resp, nil
+}
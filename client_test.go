@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Client_Do_UsesSocketAndToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "tagger.sock")
+	tokenFile := filepath.Join(dir, "token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600))
+
+	server := NewServer(NewManager(&MockIndexer{}, []struct{ Path string }{}), Listen{Socket: socket}, "secret")
+	go server.Listen()
+	defer server.Shutdown(context.Background())
+	WaitFor(t, func() bool { _, err := os.Stat(socket); return err == nil })
+
+	// the tcp address enables authentication but the socket is preferred
+	config := &Config{
+		Listen: Listen{Socket: socket, TCP: "127.0.0.1:1"},
+		Auth:   Auth{TokenFile: tokenFile},
+	}
+	client, err := NewClient(config)
+	assert.Nil(t, err)
+	var projects []ProjectInfo
+	assert.Nil(t, client.Do("GET", "/projects", nil, &projects))
+	assert.Empty(t, projects)
+
+	err = client.Do("GET", "/projects/foo", nil, nil)
+	assert.Equal(t, &ApiError{Status: http.StatusNotFound, Message: ErrProjectNotFound.Error()}, err)
+
+	config.Auth = Auth{Token: "wrong"}
+	client, err = NewClient(config)
+	assert.Nil(t, err)
+	err = client.Do("GET", "/projects", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, err.(*ApiError).Status)
+}
+
+func Test_NewClient_ReturnsError_WhenSocketIsNotASocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "tagger.sock")
+	assert.Nil(t, ioutil.WriteFile(socket, []byte{}, 0600))
+
+	_, err = NewClient(&Config{Listen: Listen{Socket: socket, TCP: "127.0.0.1:1"}, Auth: Auth{Token: "secret"}})
+	assert.EqualError(t, err, socket+" is not a socket of the current user")
+}
+
+func Test_NewClient_ReturnsError_WhenNotRunning(t *testing.T) {
+	_, err := NewClient(&Config{Listen: Listen{Socket: "/foo/bar.sock"}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,700 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kkentzo/tagger/indexers"
+	"github.com/kkentzo/tagger/utils"
+	"github.com/kkentzo/tagger/watchers"
+)
+
+// errUsage is returned by commands that are given invalid arguments
+var errUsage = errors.New("invalid arguments")
+
+// command is a subcommand of tagger
+type command struct {
+	// the arguments of the command
+	args        string
+	description string
+	// client commands talk to the running instance
+	run func(client *Client, args []string, out io.Writer) error
+	// local commands work without the running instance
+	runLocal func(configFilePath string, args []string, out io.Writer) error
+}
+
+var commands = map[string]command{
+	"add": {
+		args:        "[--json] <path>",
+		description: "Monitor a project",
+		run:         addCommand,
+	},
+	"remove": {
+		args:        "[--json] <path or id>",
+		description: "Stop monitoring a project",
+		run:         removeCommand,
+	},
+	"list": {
+		args:        "[--json]",
+		description: "List the monitored projects",
+		run:         listCommand,
+	},
+	"status": {
+		args:        "[--json] [path or id]",
+		description: "Show the indexing status of one or all projects",
+		run:         statusCommand,
+	},
+	"reindex": {
+		args:        "[--json] [--full] [--dependencies] [path or id]",
+		description: "Reindex one or all projects",
+		run:         reindexCommand,
+	},
+	"logs": {
+		args:        "[--json] [-f] [path or id]",
+		description: "Show the indexing history and follow the events of projects",
+		run:         logsCommand,
+	},
+	"resolve": {
+		args:        "<file>",
+		description: "Print the tag file of the project that contains file",
+		run:         resolveCommand,
+	},
+	"symbols": {
+		args:        "[--json] [--prefix] [--ignore-case] [--fuzzy] [--kind k] [--project p] <name>",
+		description: "Look up the definitions of a symbol",
+		run:         symbolsCommand,
+	},
+	"config": {
+		args:        "check [--json]",
+		description: "Validate the config file",
+		runLocal:    configCommand,
+	},
+	"doctor": {
+		args:        "[--json]",
+		description: "Diagnose problems with the config, the indexer and the projects",
+		runLocal:    doctorCommand,
+	},
+	"init": {
+		args:        "[--print] [--force] [dir]",
+		description: "Generate a config for the projects in dir (or the current directory)",
+		runLocal:    initCommand,
+	},
+	"index": {
+		args:        "[--full] [path...]",
+		description: "Index projects once (without the daemon) and exit",
+		runLocal:    indexCommand,
+	},
+}
+
+func usage() {
+	out := os.Stderr
+	fmt.Fprintln(out, "Usage: tagger [options] [command] [arguments]")
+	fmt.Fprintln(out, "\nCommands:")
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "  daemon\tMonitor the configured projects and serve the api (default)\n")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\t%s\n", name, commands[name].description)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "\nOptions:")
+	flag.PrintDefaults()
+}
+
+// runCommand executes a client subcommand against the running instance
+// (whose token file is found next to stateFilePath unless configured) and
+// returns the process exit status
+func runCommand(configFilePath string, stateFilePath string, args []string, out io.Writer) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		usage()
+		return ExitUsage
+	}
+	var err error
+	if cmd.runLocal != nil {
+		err = cmd.runLocal(configFilePath, args[1:], out)
+	} else {
+		var client *Client
+		config := clientConfig(configFilePath)
+		config.stateFilePath = stateFilePath
+		if client, err = NewClient(config); err == nil {
+			err = cmd.run(client, args[1:], out)
+		}
+	}
+	switch err {
+	case nil:
+		return ExitOK
+	case errUsage:
+		fmt.Fprintf(os.Stderr, "Usage: tagger [options] %s %s\n", args[0], cmd.args)
+		return ExitUsage
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		return ExitCommandError
+	}
+}
+
+// clientConfig reads the config file for the commands that do not
+// require a valid one; the defaults are used if it can not be parsed
+func clientConfig(configFilePath string) *Config {
+	config, err := LoadConfig(configFilePath)
+	if config == nil {
+		if utils.FileExists(configFilePath) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return &Config{}
+	}
+	return config
+}
+
+// parseFlags parses the arguments of a command; at most maxArgs
+// positional arguments are accepted (any number if negative)
+func parseFlags(flags *flag.FlagSet, args []string, maxArgs int) error {
+	flags.SetOutput(ioutil.Discard)
+	if err := flags.Parse(args); err != nil || (maxArgs >= 0 && flags.NArg() > maxArgs) {
+		return errUsage
+	}
+	return nil
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *bool) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "Print the output as JSON")
+	return flags, asJSON
+}
+
+// projectRef returns the id of the project referred to by arg, which may
+// be an id or a path (relative to the working directory)
+func projectRef(arg string) (string, error) {
+	if projectIDPattern.MatchString(arg) && !utils.FileExists(arg) {
+		return arg, nil
+	}
+	path, err := filepath.Abs(utils.Canonicalize(arg))
+	if err != nil {
+		return "", err
+	}
+	return ProjectID(path), nil
+}
+
+var projectIDPattern = regexp.MustCompile("^[0-9a-f]{12}$")
+
+func writeJSONTo(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// add [--json] <path>
+func addCommand(client *Client, args []string, out io.Writer) error {
+	flags, asJSON := newFlagSet("add")
+	if err := parseFlags(flags, args, 1); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+	path, err := filepath.Abs(utils.Canonicalize(flags.Arg(0)))
+	if err != nil {
+		return err
+	}
+	var info ProjectInfo
+	if err := client.Do("POST", "/projects", struct {
+		Path string `json:"path"`
+	}{path}, &info); err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSONTo(out, info)
+	}
+	fmt.Fprintf(out, "Added %s (%s)\n", info.Path, info.ID)
+	return nil
+}
+
+// remove [--json] <path or id>
+func removeCommand(client *Client, args []string, out io.Writer) error {
+	flags, asJSON := newFlagSet("remove")
+	if err := parseFlags(flags, args, 1); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+	id, err := projectRef(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var info ProjectInfo
+	if err := client.Do("GET", "/projects/"+id, nil, &info); err != nil {
+		return err
+	}
+	if err := client.Do("DELETE", "/projects/"+id, nil, nil); err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSONTo(out, info)
+	}
+	fmt.Fprintf(out, "Removed %s\n", info.Path)
+	return nil
+}
+
+// list [--json]
+func listCommand(client *Client, args []string, out io.Writer) error {
+	flags, asJSON := newFlagSet("list")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	var projects []ProjectInfo
+	if err := client.Do("GET", "/projects", nil, &projects); err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSONTo(out, projects)
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tLAST RUN\tPATH")
+	for _, project := range projects {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", project.ID, project.State, formatRun(project.LastRun), project.Path)
+	}
+	return w.Flush()
+}
+
+// status [--json] [path or id]
+func statusCommand(client *Client, args []string, out io.Writer) error {
+	flags, asJSON := newFlagSet("status")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	ids := []string{}
+	if flags.NArg() == 1 {
+		id, err := projectRef(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	} else {
+		var projects []ProjectInfo
+		if err := client.Do("GET", "/projects", nil, &projects); err != nil {
+			return err
+		}
+		for _, project := range projects {
+			ids = append(ids, project.ID)
+		}
+	}
+	statuses := []ProjectStatus{}
+	for _, id := range ids {
+		var status ProjectStatus
+		if err := client.Do("GET", "/projects/"+id+"/status", nil, &status); err != nil {
+			return err
+		}
+		statuses = append(statuses, status)
+	}
+	if *asJSON {
+		if flags.NArg() == 1 {
+			return writeJSONTo(out, statuses[0])
+		}
+		return writeJSONTo(out, statuses)
+	}
+	for i, status := range statuses {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		writeStatus(out, status)
+	}
+	return nil
+}
+
+func writeStatus(out io.Writer, status ProjectStatus) {
+	w := tabwriter.NewWriter(out, 0, 4, 1, ' ', 0)
+	fmt.Fprintf(w, "Project:\t%s (%s)\n", status.Path, status.ID)
+	fmt.Fprintf(w, "State:\t%s\n", status.State)
+	fmt.Fprintf(w, "Last run:\t%s\n", formatRun(status.LastRun))
+	if run := status.LastRun; run != nil && run.Error != "" {
+		fmt.Fprintf(w, "Error:\t%s\n", run.Error)
+	}
+	for i, tagFile := range status.TagFiles {
+		label := ""
+		if i == 0 {
+			label = "Tag files:"
+		}
+		if tagFile.Exists {
+			fmt.Fprintf(w, "%s\t%s (%d bytes, %d entries)\n", label, tagFile.Path, tagFile.Size, tagFile.Entries)
+		} else {
+			fmt.Fprintf(w, "%s\t%s (missing)\n", label, tagFile.Path)
+		}
+	}
+	if status.Symbols != nil {
+		fmt.Fprintf(w, "Symbols:\t%d in %d files (%d bytes)\n",
+			status.Symbols.Tags, status.Symbols.Files, status.Symbols.Memory)
+	}
+	w.Flush()
+}
+
+// formatRun describes the outcome of run in a few words
+func formatRun(run *Run) string {
+	if run == nil {
+		return "never"
+	}
+	outcome := "ok"
+	if run.Error != "" {
+		outcome = fmt.Sprintf("failed (exit code %d)", run.ExitCode)
+	}
+	return fmt.Sprintf("%s %s in %s", run.Start.Format("2006-01-02 15:04:05"), outcome,
+		time.Duration(run.DurationMs)*time.Millisecond)
+}
+
+// reindex [--json] [--full] [--dependencies] [path or id]
+func reindexCommand(client *Client, args []string, out io.Writer) error {
+	flags, asJSON := newFlagSet("reindex")
+	var options ReindexOptions
+	flags.BoolVar(&options.Full, "full", false, "Rebuild the tag files from scratch")
+	flags.BoolVar(&options.Dependencies, "dependencies", false, "Reindex the dependencies too")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	projects := []ProjectInfo{}
+	if flags.NArg() == 1 {
+		id, err := projectRef(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		var info ProjectInfo
+		if err := client.Do("POST", "/projects/"+id+"/reindex", options, &info); err != nil {
+			return err
+		}
+		projects = append(projects, info)
+	} else if err := client.Do("POST", "/reindex", options, &projects); err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSONTo(out, projects)
+	}
+	for _, project := range projects {
+		fmt.Fprintf(out, "Reindexing %s\n", project.Path)
+	}
+	return nil
+}
+
+// logs [--json] [-f] [path or id]
+// prints the indexing runs of one or all projects (oldest first) and,
+// with -f, follows their events
+func logsCommand(client *Client, args []string, out io.Writer) error {
+	flags, asJSON := newFlagSet("logs")
+	follow := flags.Bool("f", false, "Follow the events of the projects")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	id := ""
+	if flags.NArg() == 1 {
+		var err error
+		if id, err = projectRef(flags.Arg(0)); err != nil {
+			return err
+		}
+	}
+	var resp io.ReadCloser
+	if *follow {
+		// subscribe first so that no events are missed
+		path := "/events"
+		if id != "" {
+			path += "?project=" + url.QueryEscape(id)
+		}
+		r, err := client.Request("GET", path, nil)
+		if err != nil {
+			return err
+		}
+		resp = r.Body
+		defer resp.Close()
+	}
+	events, err := history(client, id)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := writeEvent(out, event, *asJSON); err != nil {
+			return err
+		}
+	}
+	if resp == nil {
+		return nil
+	}
+	scanner := bufio.NewScanner(resp)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event ProjectEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			return err
+		}
+		if err := writeEvent(out, event, *asJSON); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("The event stream was closed by the server")
+}
+
+// history returns the recorded runs of the project with the given id (or
+// of all projects) as indexing events, oldest first
+func history(client *Client, id string) ([]ProjectEvent, error) {
+	ids := []string{id}
+	if id == "" {
+		var projects []ProjectInfo
+		if err := client.Do("GET", "/projects", nil, &projects); err != nil {
+			return nil, err
+		}
+		ids = ids[:0]
+		for _, project := range projects {
+			ids = append(ids, project.ID)
+		}
+	}
+	events := []ProjectEvent{}
+	for _, id := range ids {
+		var status ProjectStatus
+		if err := client.Do("GET", "/projects/"+id+"/status", nil, &status); err != nil {
+			return nil, err
+		}
+		for i := range status.Runs {
+			run := status.Runs[i]
+			event := ProjectEvent{
+				Type:    EventIndexingFinished,
+				Project: status.ID,
+				Path:    status.Path,
+				Time:    run.End,
+				Run:     &run,
+			}
+			if run.Error != "" {
+				event.Type = EventIndexingFailed
+			}
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events, nil
+}
+
+func writeEvent(out io.Writer, event ProjectEvent, asJSON bool) error {
+	if asJSON {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", data)
+		return err
+	}
+	details := ""
+	switch {
+	case event.Run != nil && event.Run.Error != "":
+		details = fmt.Sprintf(" after %s: %s", time.Duration(event.Run.DurationMs)*time.Millisecond, event.Run.Error)
+	case event.Run != nil:
+		details = fmt.Sprintf(" in %s", time.Duration(event.Run.DurationMs)*time.Millisecond)
+	case len(event.Files) > 0:
+		details = fmt.Sprintf(" (%d files)", len(event.Files))
+	}
+	_, err := fmt.Fprintf(out, "%s %s %s%s\n", event.Time.Format("2006-01-02 15:04:05"), event.Type, event.Path, details)
+	return err
+}
+
+// resolve <file>
+// prints the tag file of the project that contains file
+func resolveCommand(client *Client, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	file, err := filepath.Abs(utils.Canonicalize(args[0]))
+	if err != nil {
+		return err
+	}
+	var status ProjectStatus
+	if err := client.Do("GET", "/resolve?file="+url.QueryEscape(file), nil, &status); err != nil {
+		return err
+	}
+	if len(status.TagFiles) == 0 {
+		return fmt.Errorf("%s has no tag files", status.Path)
+	}
+	fmt.Fprintln(out, status.TagFiles[0].Path)
+	return nil
+}
+
+// symbols <name> [--prefix] [--ignore-case] [--fuzzy] [--kind k] [--project p] [--json]
+// prints the definitions of the symbols that match name
+func symbolsCommand(client *Client, args []string, out io.Writer) error {
+	flags, asJSON := newFlagSet("symbols")
+	prefix := flags.Bool("prefix", false, "Match the symbols that start with name")
+	ignoreCase := flags.Bool("ignore-case", false, "Match name case-insensitively")
+	fuzzy := flags.Bool("fuzzy", false, "Match name fuzzily (best matches first)")
+	kind := flags.String("kind", "", "Match only the symbols of the given kind")
+	project := flags.String("project", "", "Search only the given project (path or id)")
+	if err := parseFlags(flags, args, -1); err != nil || flags.NArg() == 0 {
+		return errUsage
+	}
+	// flags may also follow the name
+	name := flags.Arg(0)
+	if err := parseFlags(flags, flags.Args()[1:], 0); err != nil {
+		return err
+	}
+	query := url.Values{"name": {name}}
+	for param, value := range map[string]bool{"prefix": *prefix, "ignore_case": *ignoreCase, "fuzzy": *fuzzy} {
+		if value {
+			query.Set(param, "true")
+		}
+	}
+	if *kind != "" {
+		query.Set("kind", *kind)
+	}
+	if *project != "" {
+		id, err := projectRef(*project)
+		if err != nil {
+			return err
+		}
+		query.Set("project", id)
+	}
+	var symbols []Symbol
+	if err := client.Do("GET", "/symbols?"+query.Encode(), nil, &symbols); err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSONTo(out, symbols)
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, symbol := range symbols {
+		location := symbol.File
+		if symbol.Line > 0 {
+			location = fmt.Sprintf("%s:%d", symbol.File, symbol.Line)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", symbol.Name, symbol.Kind, location)
+	}
+	return w.Flush()
+}
+
+// index [--full] [path...]
+// indexes the given projects (the current directory by default) using
+// the indexer of the config file, without watching them
+func indexCommand(configFilePath string, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("index", flag.ContinueOnError)
+	full := flags.Bool("full", false, "Rebuild the tag files from scratch")
+	if err := parseFlags(flags, args, -1); err != nil {
+		return err
+	}
+	indexer := indexers.DefaultIndexer()
+	if config := clientConfig(configFilePath); config.Indexer != nil {
+		indexer = config.Indexer
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	// running indexers are killed on interruption
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	failed := 0
+	for _, p := range paths {
+		path, err := filepath.Abs(utils.Canonicalize(p))
+		if err == nil {
+			start := time.Now()
+			if err = indexOnce(ctx, indexer, path, *full); err == nil {
+				fmt.Fprintf(out, "Indexed %s in %s\n", path, time.Since(start).Round(time.Millisecond))
+				continue
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Indexing %s failed: %s\n", path, err)
+		failed++
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d projects failed", failed, len(paths))
+	}
+	return nil
+}
+
+func indexOnce(ctx context.Context, indexer indexers.Indexable, path string, full bool) error {
+	if dir, err := utils.IsDirectory(path); err != nil {
+		return err
+	} else if !dir {
+		return ErrInvalidPath
+	}
+	event := watchers.NewEvent()
+	event.Full = full
+	return indexer.Create(path).Index(ctx, path, event)
+}
+
+// config check [--json]
+// reports all the problems of the config file
+func configCommand(configFilePath string, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "check" {
+		return errUsage
+	}
+	flags, asJSON := newFlagSet("config check")
+	if err := parseFlags(flags, args[1:], 0); err != nil {
+		return err
+	}
+	config, err := LoadConfig(configFilePath)
+	errs, invalid := err.(*ConfigErrors)
+	if err != nil && !invalid {
+		return err
+	}
+	// warnings (e.g. missing projects) do not make the config invalid
+	warnings := []ConfigError{}
+	if config != nil {
+		warnings = append(warnings, config.Warnings()...)
+	}
+	if *asJSON {
+		result := struct {
+			Path     string        `json:"path"`
+			Valid    bool          `json:"valid"`
+			Errors   []ConfigError `json:"errors"`
+			Warnings []ConfigError `json:"warnings"`
+		}{Path: configFilePath, Valid: !invalid, Errors: []ConfigError{}, Warnings: warnings}
+		if invalid {
+			result.Errors = errs.Errors
+		}
+		if err := writeJSONTo(out, result); err != nil {
+			return err
+		}
+	} else {
+		if len(warnings) > 0 {
+			report := (&ConfigErrors{Path: configFilePath, Errors: warnings}).Error()
+			for _, line := range strings.Split(report, "\n") {
+				fmt.Fprintf(out, "warning: %s\n", line)
+			}
+		}
+		if invalid {
+			fmt.Fprintln(out, errs.Error())
+		} else {
+			fmt.Fprintf(out, "%s is valid\n", configFilePath)
+		}
+	}
+	if invalid {
+		return fmt.Errorf("%d problem(s) found", len(errs.Errors))
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kkentzo/tagger/indexers"
+	"github.com/stretchr/testify/assert"
+)
+
+func CreateTestClient(t *testing.T, manager *Manager) (*Client, func()) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	socket := filepath.Join(dir, "tagger.sock")
+	server := NewServer(manager, Listen{Socket: socket}, "")
+	go server.Listen()
+	WaitFor(t, func() bool { _, err := os.Stat(socket); return err == nil })
+	client, err := NewClient(&Config{Listen: Listen{Socket: socket}})
+	assert.Nil(t, err)
+	return client, func() {
+		server.Shutdown(context.Background())
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_resolveCommand_PrintsTagFile(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	manager := NewManager(indexers.DefaultIndexer(), []struct{ Path string }{{Path: path}})
+	defer manager.Remove(path)
+	client, stop := CreateTestClient(t, manager)
+	defer stop()
+
+	var out bytes.Buffer
+	assert.Nil(t, resolveCommand(client, []string{filepath.Join(path, "foo.go")}, &out))
+	assert.Equal(t, filepath.Join(path, "TAGS")+"\n", out.String())
+
+	assert.NotNil(t, resolveCommand(client, []string{"/foo/bar.go"}, &out))
+	assert.Equal(t, errUsage, resolveCommand(client, []string{}, &out))
+}
+
+func Test_symbolsCommand_PrintsDefinitions(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	tagFile := filepath.Join(path, "tags")
+	assert.Nil(t, ioutil.WriteFile(tagFile, []byte("Foo\tfoo.go\t3;\"\tf\nFooBar\tbar.go\t7;\"\tt\n"), 0644))
+	manager := NewManager(indexers.DefaultIndexer(), []struct{ Path string }{{Path: path}})
+	defer manager.Remove(path)
+	assert.Nil(t, manager.Tags.Load(path, tagFile))
+	client, stop := CreateTestClient(t, manager)
+	defer stop()
+
+	var out bytes.Buffer
+	assert.Nil(t, symbolsCommand(client, []string{"Foo"}, &out))
+	assert.Equal(t, "Foo  f  "+filepath.Join(path, "foo.go")+":3\n", out.String())
+
+	out.Reset()
+	assert.Nil(t, symbolsCommand(client, []string{"--project", path, "foo", "--prefix", "--ignore-case",
+		"--kind", "t", "--json"}, &out))
+	var symbols []Symbol
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &symbols))
+	assert.Len(t, symbols, 1)
+	assert.Equal(t, "FooBar", symbols[0].Name)
+	assert.Equal(t, ProjectID(path), symbols[0].Project)
+
+	out.Reset()
+	assert.Nil(t, symbolsCommand(client, []string{"fb", "--fuzzy"}, &out))
+	assert.Contains(t, out.String(), "FooBar")
+
+	assert.Equal(t, errUsage, symbolsCommand(client, []string{}, &out))
+	assert.Equal(t, errUsage, symbolsCommand(client, []string{"Foo", "Bar"}, &out))
+}
+
+func Test_Commands_ManageProjects(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	manager := NewManager(indexers.DefaultIndexer(), []struct{ Path string }{})
+	client, stop := CreateTestClient(t, manager)
+	defer stop()
+
+	var out bytes.Buffer
+	assert.Nil(t, addCommand(client, []string{path}, &out))
+	assert.Equal(t, "Added "+path+" ("+ProjectID(path)+")\n", out.String())
+	assert.Equal(t, []string{path}, manager.Paths())
+	assert.NotNil(t, addCommand(client, []string{path}, &out))
+	assert.Equal(t, errUsage, addCommand(client, []string{}, &out))
+
+	out.Reset()
+	assert.Nil(t, listCommand(client, []string{"--json"}, &out))
+	var projects []ProjectInfo
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &projects))
+	assert.Len(t, projects, 1)
+	assert.Equal(t, path, projects[0].Path)
+
+	out.Reset()
+	assert.Nil(t, listCommand(client, []string{}, &out))
+	assert.Contains(t, out.String(), ProjectID(path)+"  watching  never     "+path)
+
+	out.Reset()
+	assert.Nil(t, statusCommand(client, []string{ProjectID(path)}, &out))
+	assert.Contains(t, out.String(), "State:     watching")
+	assert.Contains(t, out.String(), filepath.Join(path, "TAGS")+" (missing)")
+
+	// the manager has not been started
+	err = reindexCommand(client, []string{"--full", path}, &out)
+	assert.Equal(t, http.StatusConflict, err.(*ApiError).Status)
+	out.Reset()
+	assert.Nil(t, reindexCommand(client, []string{"--json"}, &out))
+	assert.Equal(t, "[]\n", out.String())
+
+	out.Reset()
+	assert.Nil(t, removeCommand(client, []string{path}, &out))
+	assert.Equal(t, "Removed "+path+"\n", out.String())
+	assert.Empty(t, manager.Paths())
+	err = removeCommand(client, []string{path}, &out)
+	assert.Equal(t, http.StatusNotFound, err.(*ApiError).Status)
+}
+
+func Test_logsCommand_PrintsHistory(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	manager := NewManager(indexers.DefaultIndexer(), []struct{ Path string }{{Path: path}})
+	defer manager.Remove(path)
+	status := manager.projects[path].status
+	status.End(status.Begin(), nil)
+	status.End(status.Begin(), errors.New("oops"))
+	client, stop := CreateTestClient(t, manager)
+	defer stop()
+
+	var out bytes.Buffer
+	assert.Nil(t, logsCommand(client, []string{}, &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], EventIndexingFinished+" "+path+" in ")
+	assert.Contains(t, lines[1], EventIndexingFailed+" "+path+" after ")
+	assert.True(t, strings.HasSuffix(lines[1], ": oops"))
+
+	out.Reset()
+	assert.Nil(t, logsCommand(client, []string{"--json", ProjectID(path)}, &out))
+	var event ProjectEvent
+	assert.Nil(t, json.Unmarshal([]byte(strings.Split(out.String(), "\n")[1]), &event))
+	assert.Equal(t, EventIndexingFailed, event.Type)
+	assert.Equal(t, "oops", event.Run.Error)
+}
+
+func Test_projectRef(t *testing.T) {
+	id, err := projectRef("0123456789ab")
+	assert.Nil(t, err)
+	assert.Equal(t, "0123456789ab", id)
+	id, err = projectRef("/foo/bar")
+	assert.Nil(t, err)
+	assert.Equal(t, ProjectID("/foo/bar"), id)
+}
+
+func Test_indexCommand_IndexesProjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	assert.Nil(t, os.Mkdir(first, 0755))
+	assert.Nil(t, os.Mkdir(second, 0755))
+	config := filepath.Join(dir, "config.yml")
+	WriteConfig(t, config, "indexer:\n  program: \"true\"\n  args: []\n  tag_file: TAGS\n")
+
+	var out bytes.Buffer
+	assert.Nil(t, indexCommand(config, []string{first, second}, &out))
+	assert.Contains(t, out.String(), "Indexed "+first+" in ")
+	assert.Contains(t, out.String(), "Indexed "+second+" in ")
+
+	err = indexCommand(config, []string{first, filepath.Join(dir, "missing")}, &out)
+	assert.Equal(t, "1 of 2 projects failed", err.Error())
+	assert.Equal(t, errUsage, indexCommand(config, []string{"--foo"}, &out))
+}
+
+func Test_configCommand_ReportsProblems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "config.yml")
+	WriteConfig(t, config, "indexer:\n  program: ctags\n  tag_file: TAGS\n  max_period: 1s\n")
+
+	var out bytes.Buffer
+	assert.Nil(t, configCommand(config, []string{"check"}, &out))
+	assert.Equal(t, config+" is valid\n", out.String())
+
+	WriteConfig(t, config, "indexer:\n  program: ctags\n  tag_file: TAGS\n  max_period: 0s\nfoo: 1\n")
+	out.Reset()
+	assert.Equal(t, "2 problem(s) found", configCommand(config, []string{"check"}, &out).Error())
+	assert.Equal(t, config+":4: the indexer max_period must be positive (e.g. 2s)\n"+
+		config+":5: field foo not found in type main.Config\n", out.String())
+
+	out.Reset()
+	assert.NotNil(t, configCommand(config, []string{"check", "--json"}, &out))
+	var result struct{ Errors []ConfigError }
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &result))
+	assert.Len(t, result.Errors, 2)
+
+	// missing projects are not problems
+	WriteConfig(t, config, "indexer:\n  program: ctags\n  tag_file: TAGS\n  max_period: 1s\n"+
+		"projects:\n  - path: /foo/bar\n")
+	out.Reset()
+	assert.Nil(t, configCommand(config, []string{"check"}, &out))
+	assert.Equal(t, "warning: "+config+":6: project /foo/bar does not exist or is not a directory\n"+
+		config+" is valid\n", out.String())
+
+	assert.Equal(t, errUsage, configCommand(config, []string{}, &out))
+}
@@ -1,30 +1,297 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/kkentzo/tagger/indexers"
+	"github.com/kkentzo/tagger/utils"
 	log "github.com/sirupsen/logrus"
+)
+
+// how long to wait for a burst of config file events to settle
+var ConfigSettlePeriod = 200 * time.Millisecond
 
-	yaml "gopkg.in/yaml.v2"
+const (
+	// runtime project changes are stored in a separate state file
+	PersistToState = "state"
+	// runtime project changes are written back to the config file
+	PersistToConfig = "config"
 )
 
+// DefaultConfigFilePath returns $TAGGER_CONFIG or else the first existing
+// one of $XDG_CONFIG_HOME/tagger/config.yml and the legacy ~/.tagger.yml
+// (the former if neither exists)
+func DefaultConfigFilePath() string {
+	if path := os.Getenv("TAGGER_CONFIG"); path != "" {
+		return utils.ExpandPath(path)
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	path := filepath.Join(dir, "tagger", "config.yml")
+	if legacy := filepath.Join(os.Getenv("HOME"), ".tagger.yml"); !utils.FileExists(path) && utils.FileExists(legacy) {
+		return legacy
+	}
+	return path
+}
+
 type Config struct {
-	Port     int
-	Indexer  *indexers.Indexer
-	Projects []struct{ Path string }
+	// other config files (relative to this one) that are read first: the
+	// settings of this file override theirs, while their projects and
+	// workspaces are added to its own
+	Include []string
+	// deprecated: same as a listen.tcp value of 127.0.0.1:<port>
+	Port       int
+	Listen     Listen
+	Auth       Auth
+	Indexer    *indexers.Indexer
+	Projects   []struct{ Path string }
+	Workspaces []Workspace
+	Persist    string
+	Nesting    string
+	// the files that the config was read from (the includes first)
+	files []string
+	// the problems that do not make the config invalid
+	warnings []ConfigError
+	// the state file in effect (DefaultStateFilePath() if empty)
+	stateFilePath string
+}
+
+// Files returns the files that the config was read from
+func (config *Config) Files() []string {
+	return config.files
+}
+
+// Warnings returns the problems of the config files that do not make the
+// config invalid (e.g. missing project roots)
+func (config *Config) Warnings() []ConfigError {
+	return config.warnings
+}
+
+// Listen specifies where the http api is served
+type Listen struct {
+	// the path of the unix socket (defaults to DefaultSocketPath())
+	Socket string
+	// an optional tcp address; the host defaults to 127.0.0.1
+	TCP string `yaml:"tcp"`
+}
+
+// Auth specifies the bearer token that clients must present; it is
+// required whenever a tcp address is configured, unless disabled
+type Auth struct {
+	Disabled bool
+	Token    string
+	// the file that holds (or will hold) the token if none is specified
+	// (defaults to the file token next to the state file)
+	TokenFile string `yaml:"token_file"`
+}
+
+// Listener returns the listen settings with the defaults applied
+func (config *Config) Listener() Listen {
+	listen := config.Listen
+	if listen.Socket == "" {
+		listen.Socket = DefaultSocketPath()
+	}
+	listen.Socket = utils.Canonicalize(listen.Socket)
+	if listen.TCP == "" && config.Port != 0 {
+		listen.TCP = strconv.Itoa(config.Port)
+	}
+	if listen.TCP != "" {
+		listen.TCP = tcpAddress(listen.TCP)
+	}
+	return listen
+}
+
+// tcpAddress binds addresses without a host (e.g. "1234" or ":1234") to
+// the loopback interface
+func tcpAddress(address string) string {
+	if !strings.Contains(address, ":") {
+		return "127.0.0.1:" + address
+	}
+	if strings.HasPrefix(address, ":") {
+		return "127.0.0.1" + address
+	}
+	return address
 }
 
-func NewConfig(configFilePath string) *Config {
-	config := &Config{}
-	// TODO: Make path (demo.yml) a flag // if not supplied read from ~/.tagger.yml
+// LoadConfig reads and validates the config file at configFilePath along
+// with the files that it includes; all the problems found are returned
+// as *ConfigErrors. The config is returned along with the errors unless
+// the file is not valid yaml.
+func LoadConfig(configFilePath string) (*Config, error) {
 	contents, err := ioutil.ReadFile(configFilePath)
 	if err != nil {
-		log.Fatal("Config file not found: ", configFilePath)
+		return nil, fmt.Errorf("Config file not found: %s", configFilePath)
 	}
-	err = yaml.Unmarshal(contents, config)
+	loader := &configLoader{main: configFilePath, config: &Config{}}
+	config := loader.config
+	errors, ok := loader.load(configFilePath, contents, nil)
+	if ok {
+		errors = append(errors, config.validateRequired(contents, loader.specified)...)
+		config.expandPaths()
+	} else {
+		config = nil
+	}
+	if config != nil {
+		sortConfigErrors(config.warnings)
+	}
+	if len(errors) > 0 {
+		sortConfigErrors(errors)
+		return config, &ConfigErrors{Path: configFilePath, Errors: errors}
+	}
+	return config, nil
+}
+
+// expandPaths expands the environment variables and the leading ~ of
+// the paths of the config
+func (config *Config) expandPaths() {
+	for i := range config.Projects {
+		config.Projects[i].Path = utils.ExpandPath(config.Projects[i].Path)
+	}
+	for i := range config.Workspaces {
+		config.Workspaces[i].Glob = utils.ExpandPath(config.Workspaces[i].Glob)
+	}
+	config.Listen.Socket = utils.ExpandPath(config.Listen.Socket)
+	config.Auth.TokenFile = utils.ExpandPath(config.Auth.TokenFile)
+	if indexer := config.Indexer; indexer != nil {
+		indexer.Program = utils.ExpandPath(indexer.Program)
+		indexer.Options = utils.ExpandPath(indexer.Options)
+		for i, exclude := range indexer.ExcludeDirs {
+			indexer.ExcludeDirs[i] = utils.ExpandPath(exclude)
+		}
+	}
+}
+
+// configLoader decodes a config file and its includes (recursively) into
+// a single config
+type configLoader struct {
+	main   string
+	config *Config
+	// the contents of the files read so far
+	contents [][]byte
+}
+
+// load decodes contents (read from path) into the config after their
+// includes; stack holds the files that (indirectly) include path. False
+// is returned if contents are not valid yaml.
+func (loader *configLoader) load(path string, contents []byte, stack []string) ([]ConfigError, bool) {
+	own := &Config{}
+	if ok, errors := decodeConfig(contents, own); !ok {
+		return loader.attribute(path, errors), false
+	}
+	errors := []ConfigError{}
+	included := []ConfigError{}
+	abs, _ := filepath.Abs(path)
+	stack = append(stack, abs)
+	for i, include := range own.Include {
+		file := utils.ExpandPath(include)
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		line := lineOf(contents, "include", i)
+		if abs, _ := filepath.Abs(file); utils.NewSet(stack).Has(abs) {
+			errors = append(errors, ConfigError{Line: line, Message: include + " includes itself"})
+			continue
+		}
+		fragment, err := ioutil.ReadFile(file)
+		if err != nil {
+			errors = append(errors, ConfigError{Line: line, Message: fmt.Sprintf("unable to read the include: %s", err)})
+			continue
+		}
+		errs, _ := loader.load(file, fragment, stack)
+		included = append(included, errs...)
+	}
+	// sequences are replaced when decoded, so collect them separately
+	config := loader.config
+	projects, workspaces := config.Projects, config.Workspaces
+	config.Projects, config.Workspaces, config.Include = nil, nil, nil
+	_, errs := decodeConfig(contents, config)
+	errors = append(errors, errs...)
+	errs, warnings := config.validate(contents)
+	errors = append(errors, errs...)
+	config.warnings = append(config.warnings, loader.attribute(path, warnings)...)
+	config.Projects = append(projects, config.Projects...)
+	config.Workspaces = append(workspaces, config.Workspaces...)
+	config.files = append(config.files, path)
+	loader.contents = append(loader.contents, contents)
+	return append(loader.attribute(path, errors), included...), true
+}
+
+// attribute marks errors as found in path (unless it is the main file)
+func (loader *configLoader) attribute(path string, errors []ConfigError) []ConfigError {
+	if path != loader.main {
+		for i := range errors {
+			errors[i].File = path
+		}
+	}
+	return errors
+}
+
+// specified returns true if any of the files read specifies the value at
+// path
+func (loader *configLoader) specified(path ...interface{}) bool {
+	for _, contents := range loader.contents {
+		if specifies(contents, path...) {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchConfig sends on the returned channel whenever one of the config
+// files is modified (or replaced) until ctx is cancelled
+func WatchConfig(ctx context.Context, configFilePaths ...string) (<-chan struct{}, error) {
+	w, err := fsnotify.NewWatcher()
 	if err != nil {
-		log.Fatalf("Error parsing %s: %s", configFilePath, err.Error())
+		return nil, err
+	}
+	files := utils.NewSet([]string{})
+	dirs := utils.NewSet([]string{})
+	for _, path := range configFilePaths {
+		files.Add(filepath.Clean(path))
+		dirs.Add(filepath.Dir(path))
+	}
+	// editors usually replace the file, so watch its directory instead
+	for _, dir := range dirs.Elements() {
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return nil, err
+		}
 	}
-	return config
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer w.Close()
+		settle := time.NewTimer(ConfigSettlePeriod)
+		settle.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-w.Events:
+				if !files.Has(filepath.Clean(e.Name)) ||
+					e.Op&fsnotify.Chmod == fsnotify.Chmod {
+					continue
+				}
+				log.Debugf("Config event %s on %s", e.Op, e.Name)
+				settle.Reset(ConfigSettlePeriod)
+			case <-settle.C:
+				select {
+				case changes <- struct{}{}:
+				default: // a reload is already pending
+				}
+			case err := <-w.Errors:
+				log.Error(err.Error())
+			}
+		}
+	}()
+	return changes, nil
 }
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func WriteConfig(t *testing.T, path string, contents string) {
+	err := ioutil.WriteFile(path, []byte(contents), 0644)
+	assert.Nil(t, err)
+}
+
+func Test_LoadConfig(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	fname := filepath.Join(path, "tagger.yml")
+	WriteConfig(t, fname, "port: 1234\nindexer:\n  program: ctags\n  tag_file: TAGS\n  max_period: 2s\n"+
+		"projects:\n  - path: "+path+"\n")
+	config, err := LoadConfig(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, 1234, config.Port)
+	assert.Equal(t, "ctags", config.Indexer.Program)
+	assert.Equal(t, path, config.Projects[0].Path)
+}
+
+func Test_LoadConfig_ReportsAllProblems(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	fname := filepath.Join(path, "tagger.yml")
+	WriteConfig(t, fname, `port: 0
+listen:
+  tcp: localhost:99999
+indexer:
+  program: ctags
+  tag_file: TAGS
+  max_period: -1s
+  foo: bar
+  output: xml
+projects:
+  - path: `+path+`
+  - path: /foo/bar
+workspaces:
+  - glob: "[a"
+nesting: maybe
+`)
+	config, err := LoadConfig(fname)
+	assert.NotNil(t, config)
+	errs, ok := err.(*ConfigErrors)
+	assert.True(t, ok)
+	lines := []int{}
+	for _, e := range errs.Errors {
+		lines = append(lines, e.Line)
+	}
+	assert.Equal(t, []int{1, 3, 7, 8, 9, 14, 15}, lines)
+	assert.Contains(t, err.Error(), fname+":8: field foo not found")
+	assert.Contains(t, err.Error(), fname+":9: invalid indexer output \"xml\"")
+	// missing projects are monitored until their roots appear
+	assert.Equal(t, []ConfigError{{Line: 12, Message: "project /foo/bar does not exist or is not a directory"}},
+		config.Warnings())
+
+	WriteConfig(t, fname, "port: 1\n  foo: [\n")
+	config, err = LoadConfig(fname)
+	assert.Nil(t, config)
+	assert.Equal(t, 2, err.(*ConfigErrors).Errors[0].Line)
+}
+
+func Test_lineOf(t *testing.T) {
+	contents := []byte(`# comment
+indexer:
+  program: ctags
+  args:
+    - -R
+projects:
+- path: /a
+-   path: /b
+    glob: foo
+port: 1
+`)
+	assert.Equal(t, 3, lineOf(contents, "indexer", "program"))
+	assert.Equal(t, 5, lineOf(contents, "indexer", "args", 0))
+	assert.Equal(t, 8, lineOf(contents, "projects", 1, "path"))
+	assert.Equal(t, 9, lineOf(contents, "projects", 1, "glob"))
+	assert.Equal(t, 7, lineOf(contents, "projects", 0, "glob"))
+	assert.Equal(t, 2, lineOf(contents, "indexer", "path"))
+	assert.Equal(t, 10, lineOf(contents, "port"))
+	assert.Equal(t, 0, lineOf(contents, "nesting"))
+}
+
+func Test_DefaultConfigFilePath(t *testing.T) {
+	home, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(home)
+	for _, name := range []string{"HOME", "XDG_CONFIG_HOME", "TAGGER_CONFIG"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	os.Setenv("HOME", home)
+	os.Setenv("XDG_CONFIG_HOME", "")
+	os.Setenv("TAGGER_CONFIG", "")
+
+	xdg := filepath.Join(home, ".config", "tagger", "config.yml")
+	assert.Equal(t, xdg, DefaultConfigFilePath())
+	legacy := filepath.Join(home, ".tagger.yml")
+	WriteConfig(t, legacy, "")
+	assert.Equal(t, legacy, DefaultConfigFilePath())
+	assert.Nil(t, os.MkdirAll(filepath.Dir(xdg), 0755))
+	WriteConfig(t, xdg, "")
+	assert.Equal(t, xdg, DefaultConfigFilePath())
+
+	os.Setenv("XDG_CONFIG_HOME", "/xdg")
+	assert.Equal(t, legacy, DefaultConfigFilePath())
+	os.Setenv("TAGGER_CONFIG", "~/tagger.yml")
+	assert.Equal(t, filepath.Join(home, "tagger.yml"), DefaultConfigFilePath())
+}
+
+func Test_LoadConfig_Includes(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	defer os.Unsetenv("TAGGER_TEST_DIR")
+	os.Setenv("TAGGER_TEST_DIR", path)
+
+	team := filepath.Join(path, "shared", "team.yml")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(team), 0755))
+	WriteConfig(t, team, "include:\n  - base.yml\nindexer:\n  program: $TAGGER_TEST_DIR/bin/ctags\n"+
+		"  args: [-R, -e]\n  options: $TAGGER_TEST_DIR/ctags.d/tagger.ctags\n  tag_file: TAGS\n"+
+		"  max_period: 2s\nprojects:\n  - path: "+path+"\n")
+	WriteConfig(t, filepath.Join(path, "shared", "base.yml"), "nesting: merge\nport: 1234\n")
+	fname := filepath.Join(path, "tagger.yml")
+	WriteConfig(t, fname, "include:\n  - ${TAGGER_TEST_DIR}/shared/team.yml\nport: 4321\nindexer:\n"+
+		"  max_period: 5s\nprojects:\n  - path: $TAGGER_TEST_DIR/shared\n")
+
+	config, err := LoadConfig(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, 4321, config.Port)
+	assert.Equal(t, NestingMerge, config.Nesting)
+	assert.Equal(t, filepath.Join(path, "bin", "ctags"), config.Indexer.Program)
+	assert.Equal(t, []string{"-R", "-e"}, config.Indexer.Args)
+	assert.Equal(t, filepath.Join(path, "ctags.d", "tagger.ctags"), config.Indexer.Options)
+	assert.Equal(t, 5*time.Second, config.Indexer.MaxPeriod)
+	assert.Equal(t, []struct{ Path string }{{Path: path}, {Path: filepath.Join(path, "shared")}}, config.Projects)
+	assert.Equal(t, []string{"${TAGGER_TEST_DIR}/shared/team.yml"}, config.Include)
+	assert.Equal(t, []string{filepath.Join(path, "shared", "base.yml"), team, fname}, config.Files())
+
+	// problems are reported along with the file they were found in
+	WriteConfig(t, team, "include:\n  - ../tagger.yml\n  - missing.yml\nindexer:\n  program: ctags\n"+
+		"  max_period: 0s\n")
+	config, err = LoadConfig(fname)
+	assert.NotNil(t, config)
+	assert.Equal(t, fname+":4: the indexer tag_file is required\n"+
+		team+":2: ../tagger.yml includes itself\n"+
+		team+":3: unable to read the include: open "+filepath.Join(path, "shared", "missing.yml")+
+		": no such file or directory\n"+
+		team+":6: the indexer max_period must be positive (e.g. 2s)", err.Error())
+}
+
+func Test_LoadConfig_ReturnsError_OnInvalidFile(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	fname := filepath.Join(path, "tagger.yml")
+	_, err = LoadConfig(fname)
+	assert.NotNil(t, err)
+
+	WriteConfig(t, fname, "port: [")
+	_, err = LoadConfig(fname)
+	assert.NotNil(t, err)
+}
+
+func Test_WatchConfig_Notifies_OnFileChange(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	fname := filepath.Join(path, "tagger.yml")
+	WriteConfig(t, fname, "port: 1234\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := WatchConfig(ctx, fname)
+	assert.Nil(t, err)
+
+	// changes to other files are ignored
+	WriteConfig(t, filepath.Join(path, "other.yml"), "port: 1\n")
+	select {
+	case <-changes:
+		t.Fatal("unexpected notification")
+	case <-time.After(2 * ConfigSettlePeriod):
+	}
+
+	WriteConfig(t, fname, "port: 4321\n")
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification for config change")
+	}
+}
+
+func Test_WatchConfig_Notifies_OnIncludedFileChange(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	assert.Nil(t, os.Mkdir(filepath.Join(path, "shared"), 0755))
+
+	fname := filepath.Join(path, "tagger.yml")
+	included := filepath.Join(path, "shared", "team.yml")
+	WriteConfig(t, fname, "include: [shared/team.yml]\n")
+	WriteConfig(t, included, "port: 1234\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := WatchConfig(ctx, included, fname)
+	assert.Nil(t, err)
+
+	WriteConfig(t, included, "port: 4321\n")
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification for included file change")
+	}
+}
+
+func Test_Config_Listener(t *testing.T) {
+	defer os.Setenv("XDG_RUNTIME_DIR", os.Getenv("XDG_RUNTIME_DIR"))
+	os.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+
+	var testCases = []struct {
+		config   Config
+		expected Listen
+	}{
+		{Config{}, Listen{Socket: "/run/user/1000/tagger.sock"}},
+		{Config{Port: 1234}, Listen{Socket: "/run/user/1000/tagger.sock", TCP: "127.0.0.1:1234"}},
+		{Config{Port: 1234, Listen: Listen{Socket: "/tmp/foo.sock", TCP: ":5678"}},
+			Listen{Socket: "/tmp/foo.sock", TCP: "127.0.0.1:5678"}},
+		{Config{Listen: Listen{TCP: "0.0.0.0:5678"}},
+			Listen{Socket: "/run/user/1000/tagger.sock", TCP: "0.0.0.0:5678"}},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.config.Listener())
+	}
+}
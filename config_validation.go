@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kkentzo/tagger/indexers"
+	"github.com/kkentzo/tagger/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ConfigError is a single problem of a config file
+type ConfigError struct {
+	// the included file that the problem was found in (if any)
+	File string `json:"file,omitempty"`
+	// 0 if the problem can not be attributed to a line
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// ConfigErrors holds all the problems found in a config file
+type ConfigErrors struct {
+	Path   string
+	Errors []ConfigError
+}
+
+func (e *ConfigErrors) Error() string {
+	lines := []string{}
+	for _, err := range e.Errors {
+		path := e.Path
+		if err.File != "" {
+			path = err.File
+		}
+		if err.Line > 0 {
+			lines = append(lines, fmt.Sprintf("%s:%d: %s", path, err.Line, err.Message))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: %s", path, err.Message))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// matches the line numbers of yaml errors (e.g. "line 3: field foo not
+// found in type main.Config")
+var yamlErrorPattern = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// decodeConfig decodes contents strictly (unknown fields are reported)
+// into config, overriding the settings that contents specify; false is
+// returned if contents are not valid yaml
+func decodeConfig(contents []byte, config *Config) (bool, []ConfigError) {
+	err := yaml.UnmarshalStrict(contents, config)
+	if err == nil {
+		return true, nil
+	}
+	messages := []string{err.Error()}
+	typeErr, partial := err.(*yaml.TypeError)
+	if partial {
+		messages = typeErr.Errors
+	}
+	errors := []ConfigError{}
+	for _, message := range messages {
+		if m := yamlErrorPattern.FindStringSubmatch(message); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			errors = append(errors, ConfigError{Line: line, Message: m[2]})
+		} else {
+			errors = append(errors, ConfigError{Message: strings.TrimPrefix(message, "yaml: ")})
+		}
+	}
+	return partial, errors
+}
+
+// validate returns the problems of the settings that contents (one of
+// the config files) specify along with the warnings (which do not make
+// the config invalid); contents are used for locating them
+func (config *Config) validate(contents []byte) ([]ConfigError, []ConfigError) {
+	errors := []ConfigError{}
+	warnings := []ConfigError{}
+	problem := func(message string, path ...interface{}) {
+		errors = append(errors, ConfigError{Line: lineOf(contents, path...), Message: message})
+	}
+	// settings may be inherited from included files
+	specified := func(path ...interface{}) bool {
+		return specifies(contents, path...)
+	}
+	if specified("port") && (config.Port <= 0 || config.Port > 65535) {
+		problem(fmt.Sprintf("invalid port %d", config.Port), "port")
+	}
+	if specified("listen", "tcp") && config.Listen.TCP != "" {
+		if _, port, err := net.SplitHostPort(tcpAddress(config.Listen.TCP)); err != nil {
+			problem(fmt.Sprintf("invalid tcp address %q", config.Listen.TCP), "listen", "tcp")
+		} else if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			problem(fmt.Sprintf("invalid port in tcp address %q", config.Listen.TCP), "listen", "tcp")
+		}
+	}
+	if indexer := config.Indexer; indexer != nil {
+		if specified("indexer", "tag_file") && strings.ContainsRune(indexer.TagFileName, filepath.Separator) {
+			problem("the indexer tag_file must be a file name", "indexer", "tag_file")
+		}
+		if specified("indexer", "max_period") && indexer.MaxPeriod <= 0 {
+			problem("the indexer max_period must be positive (e.g. 2s)", "indexer", "max_period")
+		}
+		if specified("indexer", "output") && indexer.Output != "" && indexer.Output != indexers.OutputJSON {
+			problem(fmt.Sprintf("invalid indexer output %q (use %q or leave it empty)",
+				indexer.Output, indexers.OutputJSON), "indexer", "output")
+		}
+	}
+	for i, project := range config.Projects {
+		if project.Path == "" {
+			problem("the project path is required", "projects", i)
+		} else if dir, err := utils.IsDirectory(utils.ExpandPath(project.Path)); err != nil || !dir {
+			// the project is monitored once its root appears
+			warnings = append(warnings, ConfigError{Line: lineOf(contents, "projects", i, "path"),
+				Message: fmt.Sprintf("project %s does not exist or is not a directory", project.Path)})
+		}
+	}
+	for i, workspace := range config.Workspaces {
+		if workspace.Glob == "" {
+			problem("the workspace glob is required", "workspaces", i)
+		} else if _, err := filepath.Match(workspace.Glob, ""); err != nil {
+			problem(fmt.Sprintf("invalid workspace glob %q", workspace.Glob), "workspaces", i, "glob")
+		}
+	}
+	switch config.Persist {
+	case "", PersistToState, PersistToConfig:
+	default:
+		if specified("persist") {
+			problem(fmt.Sprintf("invalid persist value %q (use %q or %q)",
+				config.Persist, PersistToState, PersistToConfig), "persist")
+		}
+	}
+	switch config.Nesting {
+	case "", NestingReject, NestingSubproject, NestingMerge:
+	default:
+		if specified("nesting") {
+			problem(fmt.Sprintf("invalid nesting value %q (use %q, %q or %q)",
+				config.Nesting, NestingReject, NestingSubproject, NestingMerge), "nesting")
+		}
+	}
+	return errors, warnings
+}
+
+// sortConfigErrors orders errors by file (the main one first) and line
+func sortConfigErrors(errors []ConfigError) {
+	sort.SliceStable(errors, func(i, j int) bool {
+		if errors[i].File != errors[j].File {
+			return errors[i].File < errors[j].File
+		}
+		return errors[i].Line < errors[j].Line
+	})
+}
+
+// validateRequired returns the required settings that none of the
+// config files specify; contents (the main config file) are used for
+// locating them and specified reports whether any file specifies a
+// setting
+func (config *Config) validateRequired(contents []byte, specified func(path ...interface{}) bool) []ConfigError {
+	errors := []ConfigError{}
+	problem := func(message string, path ...interface{}) {
+		errors = append(errors, ConfigError{Line: lineOf(contents, path...), Message: message})
+	}
+	if config.Indexer == nil {
+		problem("an indexer is required")
+		return errors
+	}
+	indexer := config.Indexer
+	if indexer.Program == "" {
+		problem("the indexer program is required", "indexer")
+	}
+	if indexer.TagFileName == "" {
+		problem("the indexer tag_file is required", "indexer")
+	}
+	// invalid values are reported by validate
+	if indexer.MaxPeriod <= 0 && !specified("indexer", "max_period") {
+		problem("the indexer max_period must be positive (e.g. 2s)", "indexer", "max_period")
+	}
+	return errors
+}
+
+// lineOf returns the (1-based) line of the value at path (map keys and
+// sequence indices) in the yaml document contents or the line of its
+// innermost ancestor that is found (0 for none); only the block style
+// is supported
+func lineOf(contents []byte, path ...interface{}) int {
+	line, _ := locate(contents, path...)
+	return line
+}
+
+// specifies returns true if the value at path is present in contents
+func specifies(contents []byte, path ...interface{}) bool {
+	_, depth := locate(contents, path...)
+	return depth == len(path)
+}
+
+// locate returns the line of the value at path (see lineOf) along with
+// the number of path elements that were found
+func locate(contents []byte, path ...interface{}) (int, int) {
+	lines := strings.Split(string(contents), "\n")
+	found := 0
+	start := 0
+	// the column of the enclosing key or sequence item
+	parent := -1
+	// whether the value starts on the line of a sequence item's dash
+	inline := false
+	for depth, element := range path {
+		key, isKey := element.(string)
+		match := -1
+		// the column of the keys or items of the current block
+		level := -1
+		index := 0
+		for i := start; i < len(lines) && match < 0; i++ {
+			content := strings.TrimLeft(lines[i], " ")
+			column := len(lines[i]) - len(content)
+			if content == "" || strings.HasPrefix(content, "#") {
+				continue
+			}
+			item := content == "-" || strings.HasPrefix(content, "- ")
+			if !(inline && i == start) &&
+				(column < parent || (column == parent && (isKey || !item))) {
+				break
+			}
+			if isKey {
+				if inline && i == start {
+					// skip the dash of the item
+					trimmed := strings.TrimLeft(content[1:], " ")
+					column += len(content) - len(trimmed)
+					content = trimmed
+				}
+				if level == -1 {
+					level = column
+				}
+				if column == level && strings.HasPrefix(content, key+":") {
+					match, parent = i, column
+				}
+			} else if item {
+				if level == -1 {
+					level = column
+				}
+				if column == level {
+					if index == element.(int) {
+						match, parent = i, column
+					}
+					index++
+				}
+			}
+		}
+		if match < 0 {
+			return found, depth
+		}
+		found = match + 1
+		if isKey {
+			start, inline = match+1, false
+		} else {
+			start, inline = match, true
+		}
+	}
+	return found, len(path)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kkentzo/tagger/indexers"
+	"github.com/kkentzo/tagger/utils"
+	"github.com/kkentzo/tagger/watchers"
+)
+
+// the file that holds the inotify watch limit (linux only)
+var MaxUserWatchesPath = "/proc/sys/fs/inotify/max_user_watches"
+
+// the levels of the doctor's findings
+const (
+	FindingOK      = "ok"
+	FindingWarning = "warning"
+	FindingError   = "error"
+)
+
+// Finding is the outcome of a single doctor check
+type Finding struct {
+	Level   string `json:"level"`
+	Check   string `json:"check"`
+	Message string `json:"message"`
+	// what can be done about a warning or an error
+	Hint string `json:"hint,omitempty"`
+}
+
+type findings []Finding
+
+func (f *findings) add(level string, check string, hint string, format string, args ...interface{}) {
+	*f = append(*f, Finding{Level: level, Check: check, Message: fmt.Sprintf(format, args...), Hint: hint})
+}
+
+// doctor [--json]
+// checks the config, the indexer, the watch limits and the projects
+func doctorCommand(configFilePath string, args []string, out io.Writer) error {
+	flags, asJSON := newFlagSet("doctor")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	results := diagnose(configFilePath)
+	if *asJSON {
+		if err := writeJSONTo(out, results); err != nil {
+			return err
+		}
+	} else {
+		for _, finding := range results {
+			fmt.Fprintf(out, "%-9s %s: %s\n", "["+finding.Level+"]", finding.Check, finding.Message)
+			if finding.Hint != "" {
+				fmt.Fprintf(out, "%-9s -> %s\n", "", finding.Hint)
+			}
+		}
+	}
+	errs := 0
+	for _, finding := range results {
+		if finding.Level == FindingError {
+			errs++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("%d problem(s) found", errs)
+	}
+	return nil
+}
+
+func diagnose(configFilePath string) []Finding {
+	f := &findings{}
+	config := checkConfig(f, configFilePath)
+	indexer := config.Indexer
+	if indexer == nil {
+		indexer = indexers.DefaultIndexer()
+	}
+	checkIndexer(f, indexer)
+	projects := utils.NewSet([]string{})
+	for _, p := range config.Projects {
+		projects.Add(utils.Canonicalize(p.Path))
+	}
+	watched := 0
+	for i := range config.Workspaces {
+		discovered, directories := config.Workspaces[i].Discover()
+		projects.AddAll(utils.NewSet(discovered))
+		watched += len(directories)
+	}
+	// projects added at runtime are only known to the running instance
+	for _, path := range checkDaemon(f, config) {
+		projects.Add(path)
+	}
+	for _, path := range projects.Elements() {
+		watched += checkProject(f, indexer, path)
+	}
+	checkWatchLimit(f, watched)
+	return *f
+}
+
+func checkConfig(f *findings, configFilePath string) *Config {
+	const check = "config"
+	config, err := LoadConfig(configFilePath)
+	if errs, ok := err.(*ConfigErrors); ok {
+		for _, e := range errs.Errors {
+			f.add(FindingError, check, "fix the config file (see tagger config check)", "%s",
+				(&ConfigErrors{Path: configFilePath, Errors: []ConfigError{e}}).Error())
+		}
+	} else if err != nil {
+		f.add(FindingError, check, "create a config file using tagger init or specify one using -c", "%s", err)
+	} else {
+		f.add(FindingOK, check, "", "%s is valid", configFilePath)
+	}
+	if config == nil {
+		return &Config{}
+	}
+	return config
+}
+
+func checkIndexer(f *findings, indexer *indexers.Indexer) {
+	const check = "indexer"
+	path, err := exec.LookPath(indexer.Program)
+	if err != nil {
+		f.add(FindingError, check, "install Universal Ctags (e.g. the universal-ctags package) or set indexer.program",
+			"%s was not found", indexer.Program)
+		return
+	}
+	features, err := indexers.DetectFeatures(path)
+	flavour := features.Flavour
+	switch {
+	case err != nil:
+		f.add(FindingWarning, check, "make sure that indexer.program is a ctags or etags executable",
+			"unable to determine the version of %s: %s", path, err)
+	case flavour == indexers.FlavourUnknown:
+		f.add(FindingWarning, check, "make sure that indexer.program is a ctags or etags executable",
+			"%s is not a known ctags implementation (%s)", path, features.Version)
+	default:
+		f.add(FindingOK, check, "", "%s is %s (%s)", path, features.Version, flavour)
+	}
+	if indexer.Output == indexers.OutputJSON && !features.Has("json") {
+		f.add(FindingError, check, "install Universal Ctags with json support or remove indexer.output",
+			"%s does not support json output", path)
+	}
+	if flavour == indexers.FlavourEtags {
+		for _, arg := range indexer.Args {
+			if arg == "-R" {
+				f.add(FindingWarning, check, "remove -R from indexer.args (tagger lists the files of the projects)",
+					"-R disables the regexps of etags (it does not recurse into directories)")
+			}
+		}
+	}
+}
+
+// checkDaemon returns the projects of the running instance (if any)
+func checkDaemon(f *findings, config *Config) []string {
+	const check = "daemon"
+	client, err := NewClient(config)
+	if err != nil {
+		f.add(FindingWarning, check, "start it using tagger daemon", "%s", err)
+		return nil
+	}
+	var projects []ProjectInfo
+	if err := client.Do("GET", "/projects", nil, &projects); err != nil {
+		hint := ""
+		if e, ok := err.(*ApiError); ok && e.Status == 401 {
+			hint = "make sure that the token of the config matches the daemon's"
+		}
+		f.add(FindingError, check, hint, "the api is not usable: %s", err)
+		return nil
+	}
+	f.add(FindingOK, check, "", "running with %d project(s)", len(projects))
+	paths := []string{}
+	for _, project := range projects {
+		paths = append(paths, project.Path)
+	}
+	return paths
+}
+
+// checkProject returns the number of directories that are watched for
+// the project
+func checkProject(f *findings, indexer *indexers.Indexer, path string) int {
+	check := "project " + path
+	if dir, err := utils.IsDirectory(path); err != nil || !dir {
+		f.add(FindingWarning, check, "create it or remove it from the config (or using tagger remove)",
+			"the project root does not exist or is not a directory (it is indexed once it appears)")
+		return 0
+	}
+	indexable := indexer.Create(path)
+	if rvm, ok := indexable.(*indexers.RvmIndexer); ok {
+		gemset, err := rvm.RvmHandler.GemsetPath(path)
+		if err != nil {
+			f.add(FindingWarning, check, "install rvm and the project's ruby (see .ruby-version)",
+				"the gemset of the ruby project could not be resolved using rvm: %s",
+				strings.TrimSpace(err.Error()))
+		} else if !utils.FileExists(gemset) {
+			f.add(FindingWarning, check, "install the project's gems using bundle install",
+				"the gemset directory %s does not exist", gemset)
+		} else {
+			f.add(FindingOK, check, "", "ruby project with gemset %s", gemset)
+		}
+	}
+	if tf, ok := indexable.(indexers.TagFileable); ok {
+		tagFile := tf.TagFiles(path)[0]
+		if info, err := os.Stat(tagFile); err != nil {
+			f.add(FindingWarning, check, "run tagger index "+path, "%s does not exist", tagFile)
+		} else {
+			f.add(FindingOK, check, "", "%s (%d bytes)", tagFile, info.Size())
+		}
+	}
+	directories, err := watchers.Discover(path, indexer.ExcludeDirs)
+	if err != nil {
+		f.add(FindingWarning, check, "", "unable to list the directories of the project: %s", err)
+	}
+	return len(directories)
+}
+
+func checkWatchLimit(f *findings, watched int) {
+	const check = "inotify"
+	contents, err := ioutil.ReadFile(MaxUserWatchesPath)
+	if err != nil {
+		// not linux
+		return
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		f.add(FindingWarning, check, "", "unable to parse %s: %s", MaxUserWatchesPath, err)
+		return
+	}
+	hint := fmt.Sprintf("raise the limit using sysctl fs.inotify.max_user_watches=%d "+
+		"(and persist it in /etc/sysctl.d) or exclude more directories", suggestedLimit(watched))
+	switch {
+	case watched > limit:
+		f.add(FindingError, check, hint,
+			"%d directories need to be watched but the limit is %d", watched, limit)
+	case watched > limit*8/10:
+		f.add(FindingWarning, check, hint,
+			"%d directories need to be watched, close to the limit of %d (which is shared with other programs)",
+			watched, limit)
+	default:
+		f.add(FindingOK, check, "", "%d directories need to be watched (the limit is %d)", watched, limit)
+	}
+}
+
+// suggestedLimit leaves room for growth and other programs
+func suggestedLimit(watched int) int {
+	limits := []int{8192, 65536, 524288}
+	i := sort.SearchInts(limits, watched*2)
+	if i < len(limits) {
+		return limits[i]
+	}
+	return watched * 2
+}
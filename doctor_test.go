@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func levels(results []Finding, check string) []string {
+	found := []string{}
+	for _, finding := range results {
+		if finding.Check == check {
+			found = append(found, finding.Level)
+		}
+	}
+	return found
+}
+
+func Test_diagnose_ReportsProblems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	project := filepath.Join(dir, "project")
+	assert.Nil(t, os.MkdirAll(filepath.Join(project, "lib"), 0755))
+	missing := filepath.Join(dir, "missing")
+	config := filepath.Join(dir, "config.yml")
+	WriteConfig(t, config, "listen:\n  socket: "+filepath.Join(dir, "tagger.sock")+"\n"+
+		"indexer:\n  program: /foo/bar/ctags\n  tag_file: TAGS\n"+
+		"nesting: foo\nprojects:\n  - path: "+project+"\n  - path: "+missing+"\n")
+
+	results := diagnose(config)
+	// a missing project root is not a config error
+	assert.Equal(t, []string{FindingError, FindingError}, levels(results, "config"))
+	assert.Equal(t, []string{FindingError}, levels(results, "indexer"))
+	assert.Equal(t, []string{FindingWarning}, levels(results, "daemon"))
+	assert.Equal(t, []string{FindingWarning}, levels(results, "project "+project))
+	assert.Equal(t, []string{FindingWarning}, levels(results, "project "+missing))
+
+	var out bytes.Buffer
+	assert.Equal(t, "3 problem(s) found", doctorCommand(config, []string{}, &out).Error())
+	assert.Contains(t, out.String(), "[error]   indexer: /foo/bar/ctags was not found\n")
+}
+
+func Test_checkWatchLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer func(path string) { MaxUserWatchesPath = path }(MaxUserWatchesPath)
+	MaxUserWatchesPath = filepath.Join(dir, "max_user_watches")
+	assert.Nil(t, ioutil.WriteFile(MaxUserWatchesPath, []byte("100\n"), 0644))
+
+	for watched, level := range map[int]string{10: FindingOK, 90: FindingWarning, 101: FindingError} {
+		f := &findings{}
+		checkWatchLimit(f, watched)
+		assert.Equal(t, []string{level}, levels(*f, "inotify"))
+	}
+	assert.Equal(t, 8192, suggestedLimit(101))
+	assert.Equal(t, 2000000, suggestedLimit(1000000))
+}
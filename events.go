@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kkentzo/tagger/utils"
+)
+
+// the number of events buffered for each subscriber; subscribers that
+// fall further behind are dropped
+var SubscriberBufferSize = 64
+
+// the types of project events
+const (
+	EventProjectAdded     = "project_added"
+	EventProjectRemoved   = "project_removed"
+	EventChangesDetected  = "changes_detected"
+	EventIndexingStarted  = "indexing_started"
+	EventIndexingFinished = "indexing_finished"
+	EventIndexingFailed   = "indexing_failed"
+)
+
+// ProjectEvent is published whenever something happens to a project
+type ProjectEvent struct {
+	Type    string    `json:"type"`
+	Project string    `json:"project"`
+	Path    string    `json:"path"`
+	Time    time.Time `json:"time"`
+	// the changed files (for changes_detected)
+	Files []string `json:"files,omitempty"`
+	// the completed run (for indexing_finished and indexing_failed)
+	Run *Run `json:"run,omitempty"`
+}
+
+func NewProjectEvent(eventType string, path string) ProjectEvent {
+	return ProjectEvent{
+		Type:    eventType,
+		Project: ProjectID(path),
+		Path:    path,
+		Time:    time.Now(),
+	}
+}
+
+// Subscription receives the events of a Broker
+type Subscription struct {
+	events chan ProjectEvent
+	// the ids or paths of the projects of interest (all if empty)
+	projects *utils.Set
+}
+
+// Events returns the channel of the subscription; it is closed when the
+// subscriber is dropped or unsubscribes
+func (subscription *Subscription) Events() <-chan ProjectEvent {
+	return subscription.events
+}
+
+func (subscription *Subscription) matches(event ProjectEvent) bool {
+	return subscription.projects.Len() == 0 ||
+		subscription.projects.Has(event.Project) ||
+		subscription.projects.Has(event.Path)
+}
+
+// Broker fans out project events to its subscribers without ever
+// blocking the publisher. A nil Broker discards all events.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[*Subscription]bool)}
+}
+
+// Subscribe registers a subscriber for the events of the given projects
+// (ids or paths) or of all projects if none are given
+func (broker *Broker) Subscribe(projects []string) *Subscription {
+	canonical := []string{}
+	for _, project := range projects {
+		canonical = append(canonical, project, utils.Canonicalize(project))
+	}
+	subscription := &Subscription{
+		events:   make(chan ProjectEvent, SubscriberBufferSize),
+		projects: utils.NewSet(canonical),
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	broker.subscribers[subscription] = true
+	return subscription
+}
+
+func (broker *Broker) Unsubscribe(subscription *Subscription) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if broker.subscribers[subscription] {
+		delete(broker.subscribers, subscription)
+		close(subscription.events)
+	}
+}
+
+func (broker *Broker) Publish(event ProjectEvent) {
+	if broker == nil {
+		return
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	for subscription := range broker.subscribers {
+		if !subscription.matches(event) {
+			continue
+		}
+		select {
+		case subscription.events <- event:
+		default:
+			delete(broker.subscribers, subscription)
+			close(subscription.events)
+		}
+	}
+}
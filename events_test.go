@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Broker_Publish_FiltersByProject(t *testing.T) {
+	broker := NewBroker()
+	all := broker.Subscribe(nil)
+	byID := broker.Subscribe([]string{ProjectID("/a")})
+	byPath := broker.Subscribe([]string{"/b"})
+
+	broker.Publish(NewProjectEvent(EventProjectAdded, "/a"))
+	broker.Publish(NewProjectEvent(EventProjectAdded, "/b"))
+
+	assert.Equal(t, "/a", (<-all.Events()).Path)
+	assert.Equal(t, "/b", (<-all.Events()).Path)
+	assert.Equal(t, "/a", (<-byID.Events()).Path)
+	assert.Equal(t, "/b", (<-byPath.Events()).Path)
+	assert.Len(t, byID.Events(), 0)
+	assert.Len(t, byPath.Events(), 0)
+}
+
+func Test_Broker_Publish_DropsSlowSubscribers(t *testing.T) {
+	defer func(size int) { SubscriberBufferSize = size }(SubscriberBufferSize)
+	SubscriberBufferSize = 1
+	broker := NewBroker()
+	subscription := broker.Subscribe(nil)
+
+	broker.Publish(NewProjectEvent(EventProjectAdded, "/a"))
+	broker.Publish(NewProjectEvent(EventProjectRemoved, "/a"))
+
+	event, ok := <-subscription.Events()
+	assert.True(t, ok)
+	assert.Equal(t, EventProjectAdded, event.Type)
+	_, ok = <-subscription.Events()
+	assert.False(t, ok)
+	// unsubscribing a dropped subscriber is harmless
+	broker.Unsubscribe(subscription)
+}
+
+func Test_Broker_Publish_IgnoresNilBroker(t *testing.T) {
+	var broker *Broker
+	broker.Publish(NewProjectEvent(EventProjectAdded, "/a"))
+}
@@ -0,0 +1,137 @@
+package indexers
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/kkentzo/tagger/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// the implementations of ctags (and etags) that are recognized
+const (
+	FlavourUniversal = "universal"
+	FlavourExuberant = "exuberant"
+	// GNU Emacs etags (or the ctags that ships with Emacs)
+	FlavourEtags   = "etags"
+	FlavourUnknown = "unknown"
+)
+
+// DetectFlavour runs program --version and returns the flavour of the
+// program along with the first line of its output
+func DetectFlavour(program string) (string, string, error) {
+	out, err := utils.ExecInPath(program, []string{"--version"}, ".")
+	if err != nil {
+		return FlavourUnknown, "", err
+	}
+	version := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	return flavourOf(version), version, nil
+}
+
+func flavourOf(version string) string {
+	switch {
+	case strings.HasPrefix(version, "Universal Ctags"):
+		return FlavourUniversal
+	case strings.HasPrefix(version, "Exuberant Ctags"):
+		return FlavourExuberant
+	case strings.Contains(version, "GNU Emacs"):
+		return FlavourEtags
+	default:
+		return FlavourUnknown
+	}
+}
+
+// Features describes an indexer program
+type Features struct {
+	Flavour string
+	// the first line of the output of --version
+	Version string
+	// the features reported by universal ctags --list-features (e.g. json)
+	Names []string
+}
+
+// Has returns true if the program reports the feature
+func (features *Features) Has(name string) bool {
+	for _, n := range features.Names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Recurses returns true if the program can index directories
+// recursively (i.e. it is not etags)
+func (features *Features) Recurses() bool {
+	return features.Flavour != FlavourEtags
+}
+
+// DetectFeatures returns the flavour of program along with the features
+// that it reports (universal ctags only)
+func DetectFeatures(program string) (*Features, error) {
+	flavour, version, err := DetectFlavour(program)
+	features := &Features{Flavour: flavour, Version: version, Names: []string{}}
+	if err != nil || flavour != FlavourUniversal {
+		return features, err
+	}
+	out, err := utils.ExecInPath(program, []string{"--list-features"}, ".")
+	if err != nil {
+		return features, err
+	}
+	features.Names = featuresOf(string(out))
+	return features, nil
+}
+
+// featuresOf parses the output of --list-features: a "#NAME DESCRIPTION"
+// header followed by a line per feature (older versions list the names
+// only)
+func featuresOf(out string) []string {
+	names := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		names = append(names, fields[0])
+	}
+	return names
+}
+
+// the features of the programs that have been run (keyed by their path
+// and modification time, so that upgrades are picked up)
+var detected = struct {
+	sync.Mutex
+	features map[string]*Features
+}{features: make(map[string]*Features)}
+
+// featuresOfProgram returns the (cached) features of program; the
+// features of a program that can not be run are unknown
+func featuresOfProgram(program string) *Features {
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return &Features{Flavour: FlavourUnknown, Names: []string{}}
+	}
+	key := path
+	if info, err := os.Stat(path); err == nil {
+		key = fmt.Sprintf("%s@%d", path, info.ModTime().UnixNano())
+	}
+	detected.Lock()
+	features, ok := detected.features[key]
+	detected.Unlock()
+	if ok {
+		return features
+	}
+	// failed detections are cached too, so that the program is not run
+	// (and the warning is not repeated) on every call
+	features, err = DetectFeatures(path)
+	if err != nil {
+		log.Warnf("Unable to detect the features of %s: %s", path, err)
+	}
+	detected.Lock()
+	detected.features[key] = features
+	detected.Unlock()
+	return features
+}
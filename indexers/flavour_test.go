@@ -0,0 +1,64 @@
+package indexers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_flavourOf(t *testing.T) {
+	assert.Equal(t, FlavourUniversal, flavourOf("Universal Ctags 5.9.0, Copyright (C) 2015 Universal Ctags Team"))
+	assert.Equal(t, FlavourExuberant, flavourOf("Exuberant Ctags 5.8, Copyright (C) 1996-2009 Darren Hiebert"))
+	assert.Equal(t, FlavourEtags, flavourOf("etags (GNU Emacs 27.1)"))
+	assert.Equal(t, FlavourUnknown, flavourOf("foo 1.0"))
+}
+
+func Test_DetectFlavour_ReturnsError_WhenProgramIsMissing(t *testing.T) {
+	flavour, _, err := DetectFlavour("/foo/bar/ctags")
+	assert.NotNil(t, err)
+	assert.Equal(t, FlavourUnknown, flavour)
+}
+
+func Test_featuresOf(t *testing.T) {
+	out := "#NAME          DESCRIPTION\nwildcards      can use glob matching\njson           supports json format output\n"
+	assert.Equal(t, []string{"wildcards", "json"}, featuresOf(out))
+	assert.Equal(t, []string{"regex", "option-directory"}, featuresOf("regex\noption-directory\n"))
+}
+
+func Test_DetectFeatures_ListsTheFeaturesOfUniversalCtags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	features, err := DetectFeatures(WriteUniversalCtagsScript(t, dir, ""))
+	assert.Nil(t, err)
+	assert.Equal(t, FlavourUniversal, features.Flavour)
+	assert.True(t, features.Has("json"))
+	assert.False(t, features.Has("yaml"))
+	assert.True(t, features.Recurses())
+
+	features, err = DetectFeatures(WriteEtagsScript(t, dir))
+	assert.Nil(t, err)
+	assert.Equal(t, FlavourEtags, features.Flavour)
+	assert.Empty(t, features.Names)
+	assert.False(t, features.Recurses())
+}
+
+func Test_featuresOfProgram_CachesFailedDetections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	runs := filepath.Join(dir, "runs")
+	program := filepath.Join(dir, "ctags")
+	body := "#!/bin/sh\necho run >> " + runs + "\nexit 1\n"
+	assert.Nil(t, ioutil.WriteFile(program, []byte(body), 0755))
+
+	assert.Equal(t, FlavourUnknown, featuresOfProgram(program).Flavour)
+	assert.Equal(t, FlavourUnknown, featuresOfProgram(program).Flavour)
+	contents, _ := ioutil.ReadFile(runs)
+	assert.Equal(t, "run\n", string(contents))
+}
@@ -1,9 +1,16 @@
 package indexers
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/kkentzo/tagger/tags"
 	"github.com/kkentzo/tagger/utils"
 	"github.com/kkentzo/tagger/watchers"
 	log "github.com/sirupsen/logrus"
@@ -11,18 +18,47 @@ import (
 
 const TagFileName string = "TAGS"
 
+// OutputJSON makes universal ctags produce json, from which the tag file
+// (and the symbol index) is built
+const OutputJSON = "json"
+
 type Indexable interface {
 	Create(string) Indexable
-	Index(string, watchers.Event)
+	Index(context.Context, string, watchers.Event) error
 	CreateWatcher(string) watchers.Watchable
 }
 
+// TagFileable indexers report the tag files they produce for a project
+// (the primary one first)
+type TagFileable interface {
+	TagFiles(string) []string
+}
+
+// Structured indexers also return the tags that they wrote to the
+// (primary) tag file of a project; nil if they are not known
+type Structured interface {
+	IndexTags(context.Context, string, watchers.Event) ([]tags.Tag, error)
+}
+
+// Nestable indexers can exclude nested projects from a project's index
+type Nestable interface {
+	WithSubprojects([]string) Indexable
+}
+
 type Indexer struct {
 	Program     string
 	Args        []string
 	TagFileName string        `yaml:"tag_file"`
 	ExcludeDirs []string      `yaml:"exclude"`
 	MaxPeriod   time.Duration `yaml:"max_period"`
+	// a ctags options file (relative to the project root unless absolute)
+	// that is passed to ctags if it exists
+	Options string
+	// either empty (ctags writes the tag file) or OutputJSON
+	Output string
+	// nested projects (absolute paths) that are excluded from the index
+	// and referenced through etags include entries instead
+	Subprojects []string `yaml:"-"`
 }
 
 func DefaultIndexer() *Indexer {
@@ -34,8 +70,92 @@ func DefaultIndexer() *Indexer {
 	}
 }
 
-func (indexer *Indexer) Index(root string, event watchers.Event) {
-	indexer.indexProject(root)
+// Index (re)creates the tag file of the project at root; the new tag
+// file replaces the existing one only once it is complete
+func (indexer *Indexer) Index(ctx context.Context, root string, event watchers.Event) error {
+	_, err := indexer.IndexTags(ctx, root, event)
+	return err
+}
+
+// IndexTags is the same as Index but also returns the tags of the
+// project if the output is json
+func (indexer *Indexer) IndexTags(ctx context.Context, root string, event watchers.Event) ([]tags.Tag, error) {
+	path := filepath.Join(root, indexer.TagFileName)
+	tmp, err := tempFileName(path)
+	if err != nil {
+		return nil, err
+	}
+	defer removeFile(tmp)
+	if err := indexer.indexProject(ctx, root, filepath.Base(tmp)); err != nil {
+		return nil, err
+	}
+	if !utils.FileExists(tmp) {
+		// nothing was written
+		return nil, nil
+	}
+	var found []tags.Tag
+	if indexer.Output == OutputJSON {
+		if found, err = indexer.convert(tmp); err != nil {
+			return nil, fmt.Errorf("json: %s", err)
+		}
+	}
+	if len(indexer.Subprojects) > 0 && ctx.Err() == nil {
+		if err := indexer.includeSubprojects(tmp); err != nil {
+			return nil, fmt.Errorf("include: %s", err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return found, os.Rename(tmp, path)
+}
+
+// convert replaces the json tags of tagFile with their etags (or ctags)
+// form and returns them
+func (indexer *Indexer) convert(tagFile string) ([]tags.Tag, error) {
+	found, err := tags.ParseJSONFile(tagFile)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(tagFile)
+	if err != nil {
+		return nil, err
+	}
+	if indexer.IsEtags() {
+		err = tags.WriteEtags(f, found)
+	} else {
+		err = tags.WriteCtags(f, found)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return found, f.Close()
+}
+
+func (indexer *Indexer) TagFiles(root string) []string {
+	return []string{filepath.Join(root, indexer.TagFileName)}
+}
+
+// WithSubprojects returns a copy of the indexer that excludes the given
+// nested projects
+func (indexer *Indexer) WithSubprojects(subprojects []string) Indexable {
+	nested := *indexer
+	nested.Subprojects = subprojects
+	return &nested
+}
+
+// IsEtags returns true if the indexer produces emacs-style tag files
+func (indexer *Indexer) IsEtags() bool {
+	if filepath.Base(indexer.Program) == "etags" {
+		return true
+	}
+	for _, arg := range indexer.Args {
+		if arg == "-e" || arg == "--output-format=etags" {
+			return true
+		}
+	}
+	return false
 }
 
 func (indexer *Indexer) Create(root string) Indexable {
@@ -51,34 +171,210 @@ func (indexer *Indexer) Create(root string) Indexable {
 }
 
 func (indexer *Indexer) CreateWatcher(root string) watchers.Watchable {
-	return watchers.NewWatcher(root, indexer.ExcludeDirs,
+	exclusions := append([]string{}, indexer.ExcludeDirs...)
+	exclusions = append(exclusions, indexer.Subprojects...)
+	return watchers.NewWatcher(root, exclusions,
 		indexer.TagFileName, indexer.MaxPeriod)
 }
 
-func (indexer *Indexer) indexProject(root string) {
-	args := indexer.GetProjectArguments(root)
-	out, err := utils.ExecInPath(indexer.Program, args, root)
-	if err != nil {
-		log.Error(string(out), err.Error())
+// Features returns the (detected) features of the indexer program
+func (indexer *Indexer) Features() *Features {
+	return featuresOfProgram(indexer.Program)
+}
+
+// indexProject runs the indexer in root writing the tags to tagFile
+// (relative to root)
+func (indexer *Indexer) indexProject(ctx context.Context, root string, tagFile string) error {
+	if indexer.Output == OutputJSON && !indexer.Features().Has("json") {
+		return fmt.Errorf("json output requires Universal Ctags with json support (see %s --list-features)",
+			indexer.Program)
+	}
+	args, listed := indexer.getArguments(root, tagFile)
+	var input io.Reader
+	if listed {
+		files, err := indexer.listFiles(root)
+		if err != nil {
+			return err
+		}
+		input = strings.NewReader(strings.Join(files, "\n") + "\n")
 	}
+	_, err := utils.ExecInPathWithInput(ctx, indexer.Program, args, root, input)
+	return err
 }
 
 func (indexer *Indexer) GetProjectArguments(root string) []string {
-	args := indexer.GetGenericArguments(root)
-	args = append(args, fmt.Sprintf("-f %s", indexer.TagFileName))
-	args = append(args, ".")
+	args, _ := indexer.getArguments(root, indexer.TagFileName)
 	return args
 }
 
+// getArguments returns the arguments that make the indexer write the tags
+// of the project at root to tagFile; if ctags can not recurse into the
+// project (because it is etags or -R is not given), the files of the
+// project are listed on its standard input and listed is true
+func (indexer *Indexer) getArguments(root string, tagFile string) (args []string, listed bool) {
+	features := indexer.Features()
+	args = indexer.GetGenericArguments(root)
+	if indexer.Output == OutputJSON {
+		// these override any output format of the user's arguments
+		args = append(args, "--output-format=json", "--fields=+n")
+	}
+	args = append(args, indexer.outputArguments(features, tagFile)...)
+	switch {
+	case features.Flavour == FlavourEtags:
+		// a file name of - reads the file names from stdin
+		return append(args, "-"), true
+	case features.Flavour == FlavourUnknown || indexer.recursive():
+		return append(args, "."), false
+	default:
+		return append(args, "-L", "-"), true
+	}
+}
+
+// outputArguments return the arguments that set the tag file
+func (indexer *Indexer) outputArguments(features *Features, tagFile string) []string {
+	if features.Flavour == FlavourEtags {
+		return []string{"-o", tagFile}
+	}
+	return []string{"-f", tagFile}
+}
+
+// recursive returns true if the arguments make ctags recurse into
+// directories
+func (indexer *Indexer) recursive() bool {
+	for _, arg := range indexer.Args {
+		if arg == "-R" || arg == "--recurse" || arg == "--recurse=yes" {
+			return true
+		}
+	}
+	return false
+}
+
+// GetGenericArguments returns the arguments of the user along with the
+// options file and the exclusions of the project at root (if supported)
 func (indexer *Indexer) GetGenericArguments(root string) []string {
 	var args []string
 	// add user-requested arguments
 	args = append(args, indexer.Args...)
-	// add excluded paths
-	exclusions := []string{}
+	if indexer.Features().Flavour == FlavourEtags {
+		// etags supports neither: the excluded files are not listed
+		return args
+	}
+	if options := indexer.optionsFile(root); options != "" {
+		args = append(args, "--options="+options)
+	}
+	// add excluded paths; ctags is run in root, so paths within the
+	// project are relative to "."
 	for _, excl := range indexer.ExcludeDirs {
-		exclusions = append(exclusions, fmt.Sprintf("--exclude=%s", excl))
+		if !filepath.IsAbs(excl) {
+			args = append(args, fmt.Sprintf("--exclude=%s", excl))
+		} else if rel, ok := relative(root, excl); ok {
+			args = append(args, fmt.Sprintf("--exclude=./%s", rel))
+		}
+	}
+	for _, subproject := range indexer.Subprojects {
+		if rel, ok := relative(root, subproject); ok {
+			args = append(args, fmt.Sprintf("--exclude=./%s", rel))
+		}
 	}
-	args = append(args, exclusions...)
 	return args
 }
+
+// optionsFile returns the path of the options file of the project at
+// root (empty if there is none)
+func (indexer *Indexer) optionsFile(root string) string {
+	if indexer.Options == "" {
+		return ""
+	}
+	path := indexer.Options
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	if !utils.FileExists(path) {
+		return ""
+	}
+	return path
+}
+
+// listFiles returns the files of the project at root (relative to root)
+// except for the excluded directories, the subprojects and the tag files
+func (indexer *Indexer) listFiles(root string) ([]string, error) {
+	excluded := utils.NewSet(indexer.Subprojects)
+	names := utils.NewSet([]string{})
+	for _, excl := range indexer.ExcludeDirs {
+		if filepath.IsAbs(excl) {
+			excluded.Add(filepath.Clean(excl))
+		} else {
+			names.Add(excl)
+		}
+	}
+	files := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && (names.Has(info.Name()) || excluded.Has(path)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := info.Name()
+		if !info.Mode().IsRegular() || strings.HasPrefix(name, indexer.TagFileName) ||
+			strings.HasPrefix(name, "."+indexer.TagFileName+".") {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		files = append(files, rel)
+		return nil
+	})
+	return files, err
+}
+
+// relative returns path relative to root if it is within root
+func relative(root string, path string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return rel, true
+}
+
+// removeFile removes path unless it does not exist
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// tempFileName returns an unused path next to path; the watchers ignore
+// it as long as path is a tag file
+func tempFileName(path string) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return f.Name(), os.Remove(f.Name())
+}
+
+// includeSubprojects appends an include entry for the tag file of every
+// subproject to tagFile (supported only by etags)
+func (indexer *Indexer) includeSubprojects(tagFile string) error {
+	if !indexer.IsEtags() {
+		log.Debug("Tag file includes are only supported for etags -- skipping ", tagFile)
+		return nil
+	}
+	f, err := os.OpenFile(tagFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, subproject := range indexer.Subprojects {
+		include := filepath.Join(subproject, indexer.TagFileName)
+		if _, err := fmt.Fprintf(f, "\x0c\n%s,include\n", include); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,9 +1,11 @@
 package indexers
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
@@ -45,7 +47,7 @@ func Test_Indexer_Index_ShouldTriggerCommand(t *testing.T) {
 
 	indexer := DefaultIndexer()
 
-	indexer.Index(path, watchers.Event{})
+	indexer.Index(context.Background(), path, watchers.Event{})
 	assert.True(t, utils.FileExists(filepath.Join(path, indexer.TagFileName)))
 }
 
@@ -69,6 +71,199 @@ func Test_Indexer_GetProjectArguments(t *testing.T) {
 	indexer := DefaultIndexer()
 	args := indexer.GetProjectArguments("foo")
 	CheckGenericArguments(t, args)
-	assert.Contains(t, args, "-f TAGS")
-	assert.Equal(t, ".", args[len(args)-1])
+	assert.Equal(t, []string{"-f", "TAGS", "."}, args[len(args)-3:])
+}
+
+func Test_Indexer_GetGenericArguments_ExcludesSubprojects(t *testing.T) {
+	indexer := DefaultIndexer().WithSubprojects([]string{"/foo/bar/baz"}).(*Indexer)
+	args := indexer.GetGenericArguments("/foo")
+	CheckGenericArguments(t, args)
+	assert.Contains(t, args, "--exclude=./bar/baz")
+}
+
+func Test_Indexer_WithSubprojects_ReturnsACopy(t *testing.T) {
+	indexer := DefaultIndexer()
+	nested := indexer.WithSubprojects([]string{"/foo/bar"}).(*Indexer)
+	assert.Empty(t, indexer.Subprojects)
+	assert.Equal(t, []string{"/foo/bar"}, nested.Subprojects)
+}
+
+func Test_Indexer_IsEtags(t *testing.T) {
+	assert.True(t, DefaultIndexer().IsEtags())
+	assert.True(t, (&Indexer{Program: "/usr/bin/etags"}).IsEtags())
+	assert.False(t, (&Indexer{Program: "ctags", Args: []string{"-R"}}).IsEtags())
+}
+
+func Test_Indexer_includeSubprojects_AppendsIncludeEntries(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	f := TouchFile(t, filepath.Join(path, "TAGS"))
+	f.Write([]byte("tags"))
+	f.Close()
+	subproject := filepath.Join(path, "sub")
+	indexer := DefaultIndexer().WithSubprojects([]string{subproject}).(*Indexer)
+	assert.Nil(t, indexer.includeSubprojects(filepath.Join(path, "TAGS")))
+
+	contents, err := ioutil.ReadFile(filepath.Join(path, "TAGS"))
+	assert.Nil(t, err)
+	assert.Equal(t, "tags\x0c\n"+filepath.Join(subproject, "TAGS")+",include\n", string(contents))
+}
+
+func Test_Indexer_Index_ShouldKeepTagFile_WhenFullEventFails(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	f := TouchFile(t, filepath.Join(path, "TAGS"))
+	f.Write([]byte("old"))
+	f.Close()
+
+	indexer := &Indexer{Program: "false", TagFileName: "TAGS"}
+	event := watchers.NewEvent()
+	event.Full = true
+	assert.NotNil(t, indexer.Index(context.Background(), path, event))
+	contents, _ := ioutil.ReadFile(filepath.Join(path, "TAGS"))
+	assert.Equal(t, "old", string(contents))
+}
+
+// WriteIndexerScript creates a fake indexer that writes contents to the
+// tag file given by its -f argument and exits with status
+func WriteIndexerScript(t *testing.T, dir string, contents string, status int) string {
+	script := filepath.Join(dir, "indexer.sh")
+	body := "#!/bin/sh\nwhile [ $# -gt 0 ]; do case \"$1\" in -f) shift; printf '" + contents + "' > \"$1\";; esac; " +
+		"shift; done\nexit " + strconv.Itoa(status) + "\n"
+	assert.Nil(t, ioutil.WriteFile(script, []byte(body), 0755))
+	return script
+}
+
+func Test_Indexer_Index_ReplacesTagFileAtomically(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	bin, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(bin)
+
+	indexer := &Indexer{Program: WriteIndexerScript(t, bin, "new", 0), TagFileName: "TAGS"}
+	assert.Nil(t, indexer.Index(context.Background(), path, watchers.NewEvent()))
+	contents, _ := ioutil.ReadFile(filepath.Join(path, "TAGS"))
+	assert.Equal(t, "new", string(contents))
+
+	// a failed run leaves the existing tag file intact
+	indexer.Program = WriteIndexerScript(t, bin, "partial", 1)
+	assert.NotNil(t, indexer.Index(context.Background(), path, watchers.NewEvent()))
+	contents, _ = ioutil.ReadFile(filepath.Join(path, "TAGS"))
+	assert.Equal(t, "new", string(contents))
+
+	files, _ := ioutil.ReadDir(path)
+	assert.Len(t, files, 1)
+}
+
+// WriteUniversalCtagsScript creates a fake universal ctags (with json
+// support) that writes contents to the tag file given by its -f argument
+func WriteUniversalCtagsScript(t *testing.T, dir string, contents string) string {
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "tags.json"), []byte(contents), 0644))
+	script := filepath.Join(dir, "ctags")
+	body := `#!/bin/sh
+case "$1" in
+  --version) echo "Universal Ctags 6.0.0, Copyright (C) 2015-2022 Universal Ctags Team"; exit 0;;
+  --list-features) printf '#NAME DESCRIPTION\njson supports json format output\n'; exit 0;;
+esac
+while [ $# -gt 0 ]; do case "$1" in -f) shift; cp "` + dir + `/tags.json" "$1";; esac; shift; done
+`
+	assert.Nil(t, ioutil.WriteFile(script, []byte(body), 0755))
+	return script
+}
+
+// WriteEtagsScript creates a fake etags that writes the file names that
+// it reads from stdin to the tag file given by its -o argument
+func WriteEtagsScript(t *testing.T, dir string) string {
+	script := filepath.Join(dir, "etags")
+	body := `#!/bin/sh
+if [ "$1" = --version ]; then echo "etags (GNU Emacs 29.1)"; exit 0; fi
+while [ $# -gt 0 ]; do case "$1" in -o) shift; out="$1";; -) cat > "$out";; esac; shift; done
+`
+	assert.Nil(t, ioutil.WriteFile(script, []byte(body), 0755))
+	return script
+}
+
+func Test_Indexer_Index_BuildsTagFileFromJson(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	bin, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(bin)
+
+	json := `{"_type": "ptag", "name": "JSON_OUTPUT_VERSION", "path": "0.0", "pattern": "in development"}
+{"_type": "tag", "name": "main", "path": "main.go", "pattern": "/^func main() {$/", "line": 7, "kind": "func"}
+{"_type": "tag", "name": "Run", "path": "cmd/run.go", "pattern": "/^func Run(path string) error {$/", "line": 3, "kind": "func"}
+`
+	indexer := &Indexer{Program: WriteUniversalCtagsScript(t, bin, json), Args: []string{"-R", "-e"},
+		TagFileName: "TAGS", Output: OutputJSON}
+	args := indexer.GetProjectArguments(path)
+	assert.Equal(t, []string{"--output-format=json", "--fields=+n", "-f", "TAGS", "."}, args[len(args)-5:])
+
+	found, err := indexer.IndexTags(context.Background(), path, watchers.NewEvent())
+	assert.Nil(t, err)
+	assert.Len(t, found, 2)
+	contents, err := ioutil.ReadFile(filepath.Join(path, "TAGS"))
+	assert.Nil(t, err)
+	assert.Equal(t, "\x0c\ncmd/run.go,16\nfunc Run\x7fRun\x013,\n\x0c\nmain.go,18\nfunc main\x7fmain\x017,\n",
+		string(contents))
+
+	// the json output is converted to the ctags format unless -e is given
+	indexer.Args = []string{"-R"}
+	assert.Nil(t, indexer.Index(context.Background(), path, watchers.NewEvent()))
+	contents, err = ioutil.ReadFile(filepath.Join(path, "TAGS"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(contents), "main\tmain.go\t/^func main() {$/;\"\tkind:func\tline:7\n")
+}
+
+func Test_Indexer_Index_RequiresJsonSupport(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	indexer := &Indexer{Program: "true", TagFileName: "TAGS", Output: OutputJSON}
+	err = indexer.Index(context.Background(), path, watchers.NewEvent())
+	assert.Contains(t, err.Error(), "json output requires Universal Ctags")
+}
+
+func Test_Indexer_Index_ListsFilesForEtags(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	bin, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(bin)
+	for _, file := range []string{"a.c", "lib/b.c", "node_modules/x.js", "build/c.o", "sub/d.c", "TAGS"} {
+		assert.Nil(t, os.MkdirAll(filepath.Dir(filepath.Join(path, file)), 0755))
+		TouchFile(t, filepath.Join(path, file)).Close()
+	}
+
+	indexer := &Indexer{Program: WriteEtagsScript(t, bin), TagFileName: "TAGS",
+		ExcludeDirs: []string{"node_modules", filepath.Join(path, "build")}}
+	indexer = indexer.WithSubprojects([]string{filepath.Join(path, "sub")}).(*Indexer)
+	assert.Equal(t, []string{"-o", "TAGS", "-"}, indexer.GetProjectArguments(path))
+
+	assert.Nil(t, indexer.Index(context.Background(), path, watchers.NewEvent()))
+	contents, err := ioutil.ReadFile(filepath.Join(path, "TAGS"))
+	assert.Nil(t, err)
+	assert.Equal(t, "a.c\nlib/b.c\n\x0c\n"+filepath.Join(path, "sub", "TAGS")+",include\n", string(contents))
+}
+
+func Test_Indexer_GetGenericArguments_TranslatesExclusionsAndOptions(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	indexer := &Indexer{Program: "ctags", Args: []string{"-R"}, Options: ".tagger.ctags",
+		ExcludeDirs: []string{"vendor", filepath.Join(path, "build"), "/elsewhere"}}
+	assert.Equal(t, []string{"-R", "--exclude=vendor", "--exclude=./build"}, indexer.GetGenericArguments(path))
+
+	TouchFile(t, filepath.Join(path, ".tagger.ctags")).Close()
+	assert.Equal(t, []string{"-R", "--options=" + filepath.Join(path, ".tagger.ctags"), "--exclude=vendor",
+		"--exclude=./build"}, indexer.GetGenericArguments(path))
 }
@@ -1,9 +1,12 @@
 package indexers
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"path/filepath"
 
+	"github.com/kkentzo/tagger/tags"
 	"github.com/kkentzo/tagger/utils"
 	"github.com/kkentzo/tagger/watchers"
 	log "github.com/sirupsen/logrus"
@@ -18,14 +21,22 @@ func (indexer *RvmIndexer) Create(root string) Indexable {
 	return indexer
 }
 
-func (indexer *RvmIndexer) Index(root string, event watchers.Event) {
+// Index indexes the gemset (if necessary) and the project; a gemset
+// failure is reported only if the project was indexed successfully
+func (indexer *RvmIndexer) Index(ctx context.Context, root string, event watchers.Event) error {
+	var gemsetErr error
 	// Index the gemset (if necessary)
-	if event.Names.Has("Gemfile.lock") || !indexer.GemsetTagFileExists(root) {
-		indexer.indexGemset(root)
+	if event.Full || event.Dependencies || event.Names.Has("Gemfile.lock") || !indexer.GemsetTagFileExists(root) {
+		gemsetErr = indexer.indexGemset(ctx, root)
 		event.Names.Remove("Gemfile.lock")
 	}
 	// Index the project
-	indexer.Indexer.Index(root, event)
+	if err := indexer.Indexer.Index(ctx, root, event); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	// Join the tag files
 	tagFiles := []string{
 		indexer.TagFileName,
@@ -34,26 +45,65 @@ func (indexer *RvmIndexer) Index(root string, event watchers.Event) {
 	// TODO: should this be more aggressive if one of the files does not exist?
 	err := utils.ConcatFiles(filepath.Join(root, indexer.TagFileName), tagFiles, root)
 	if err != nil {
-		log.Error("concat:", tagFiles, err.Error())
+		return fmt.Errorf("concat: %s", err)
+	}
+	if gemsetErr != nil {
+		return fmt.Errorf("gemset: %s", gemsetErr)
 	}
+	return nil
+}
+
+// IndexTags does not return the tags because the tag file of the
+// project includes those of the gemset
+func (indexer *RvmIndexer) IndexTags(ctx context.Context, root string, event watchers.Event) ([]tags.Tag, error) {
+	return nil, indexer.Index(ctx, root, event)
 }
 
-func (indexer *RvmIndexer) indexGemset(root string) {
-	if indexer.RvmHandler.IsRuby(root) {
-		args := indexer.GetGemsetArguments(root)
-		if len(args) == 0 {
-			return
-		}
-		out, err := utils.ExecInPath(indexer.Program, args, root)
-		if err != nil {
-			log.Error(string(out), err.Error())
-		}
+func (indexer *RvmIndexer) TagFiles(root string) []string {
+	return []string{
+		filepath.Join(root, indexer.TagFileName),
+		indexer.GetTagFileNameForGemset(root),
 	}
 }
 
+// indexGemset writes the gemset tags to a temporary file that replaces
+// the gemset tag file only if indexing succeeds
+func (indexer *RvmIndexer) indexGemset(ctx context.Context, root string) error {
+	if !indexer.RvmHandler.IsRuby(root) {
+		return nil
+	}
+	path := indexer.GetTagFileNameForGemset(root)
+	tmp, err := tempFileName(path)
+	if err != nil {
+		return err
+	}
+	defer removeFile(tmp)
+	args := indexer.getGemsetArguments(root, filepath.Base(tmp))
+	if len(args) == 0 {
+		return nil
+	}
+	if _, err := utils.ExecInPathWithContext(ctx, indexer.Program, args, root); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !utils.FileExists(tmp) {
+		// nothing was written
+		return nil
+	}
+	return os.Rename(tmp, path)
+}
+
 func (indexer *RvmIndexer) GetGemsetArguments(root string) []string {
+	return indexer.getGemsetArguments(root, indexer.TagFileName+".gemset")
+}
+
+// getGemsetArguments returns the arguments for writing the gemset tags
+// to tagFile (relative to root)
+func (indexer *RvmIndexer) getGemsetArguments(root string, tagFile string) []string {
 	args := indexer.GetGenericArguments(root)
-	args = append(args, fmt.Sprintf("-f %s.gemset", indexer.TagFileName))
+	args = append(args, indexer.outputArguments(indexer.Features(), tagFile)...)
 	if gemsetPath, err := indexer.RvmHandler.GemsetPath(root); err != nil {
 		log.Error("Can not determine gemset path for rvm project at ", root)
 		return []string{}
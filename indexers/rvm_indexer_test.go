@@ -1,6 +1,7 @@
 package indexers
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"os"
@@ -25,7 +26,7 @@ func Test_RvmIndexer_Index_ShouldIndexGemset_WhenGemsetTagFile_DoesNotExist(t *t
 	}
 	rvm.On("GemsetPath", path).Return(path, nil)
 	rvm.On("IsRuby", path).Return(true)
-	indexer.Index(path, watchers.NewEvent())
+	indexer.Index(context.Background(), path, watchers.NewEvent())
 	assert.True(t, utils.FileExists(filepath.Join(path, "TAGS.gemset")))
 	assert.True(t, utils.FileExists(filepath.Join(path, "TAGS")))
 }
@@ -45,7 +46,7 @@ func Test_RvmIndexer_Index_ShouldIndexGemset_WhenEventNames_ContainGemfileLock(t
 
 	event := watchers.NewEvent()
 	event.Names.Add("Gemfile.lock")
-	indexer.Index(path, event)
+	indexer.Index(context.Background(), path, event)
 	assert.True(t, utils.FileExists(filepath.Join(path, "TAGS.gemset")))
 	assert.True(t, utils.FileExists(filepath.Join(path, "TAGS")))
 	assert.False(t, event.Names.Has("Gemfile.lock"))
@@ -70,11 +71,42 @@ func Test_RvmIndexer_Index_ShouldConcatTagFiles(t *testing.T) {
 
 	event := watchers.NewEvent()
 	event.Names.Add("Gemfile.lock")
-	indexer.Index(path, event)
+	indexer.Index(context.Background(), path, event)
 	contents, _ := ioutil.ReadFile(filepath.Join(path, "TAGS"))
 	assert.Equal(t, 2, strings.Count(string(contents), "hello.rb,24"))
 }
 
+func Test_RvmIndexer_Index_ReplacesGemsetTagFileAtomically(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	bin, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(bin)
+
+	rvm := &MockRvmHandler{}
+	indexer := RvmIndexer{
+		Indexer:    &Indexer{Program: WriteIndexerScript(t, bin, "new", 0), TagFileName: "TAGS"},
+		RvmHandler: rvm,
+	}
+	rvm.On("GemsetPath", path).Return(bin, nil)
+	rvm.On("IsRuby", path).Return(true)
+	assert.Nil(t, indexer.Index(context.Background(), path, watchers.NewEvent()))
+	contents, _ := ioutil.ReadFile(filepath.Join(path, "TAGS.gemset"))
+	assert.Equal(t, "new", string(contents))
+
+	// a failed run leaves the existing gemset tag file intact
+	indexer.Program = WriteIndexerScript(t, bin, "partial", 1)
+	event := watchers.NewEvent()
+	event.Dependencies = true
+	assert.NotNil(t, indexer.Index(context.Background(), path, event))
+	contents, _ = ioutil.ReadFile(filepath.Join(path, "TAGS.gemset"))
+	assert.Equal(t, "new", string(contents))
+
+	files, _ := ioutil.ReadDir(path)
+	assert.Len(t, files, 2)
+}
+
 func Test_RvmIndexer_GetGemsetArguments_WhenGemsetPathCanBeDetermined(t *testing.T) {
 	rvm := &MockRvmHandler{}
 	indexer := RvmIndexer{
@@ -86,8 +118,7 @@ func Test_RvmIndexer_GetGemsetArguments_WhenGemsetPathCanBeDetermined(t *testing
 	args := indexer.GetGemsetArguments("project_path")
 	CheckGenericArguments(t, args)
 
-	assert.Contains(t, args, "-f TAGS.gemset")
-	assert.Equal(t, "gemset_path", args[len(args)-1])
+	assert.Equal(t, []string{"-f", "TAGS.gemset", "gemset_path"}, args[len(args)-3:])
 }
 
 func Test_RvmIndexer_GetGemsetArguments_WhenGemsetPathCanNotBeDetermined(t *testing.T) {
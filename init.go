@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kkentzo/tagger/indexers"
+	"github.com/kkentzo/tagger/utils"
+)
+
+// ecosystem describes the projects of a language (or package manager)
+type ecosystem struct {
+	name string
+	// the files that identify the ecosystem in a project root
+	markers []string
+	// the ctags names of the languages of the ecosystem
+	languages []string
+	// the directories that should not be indexed (or watched)
+	excludes []string
+}
+
+var ecosystems = []ecosystem{
+	{"ruby", []string{"Gemfile"}, []string{"Ruby"}, []string{"log", "tmp"}},
+	{"node", []string{"package.json"}, []string{"JavaScript", "TypeScript"}, []string{"node_modules", "dist"}},
+	{"go", []string{"go.mod"}, []string{"Go"}, []string{"vendor"}},
+	{"rust", []string{"Cargo.toml"}, []string{"Rust"}, []string{"target"}},
+	{"python", []string{"pyproject.toml", "setup.py", "requirements.txt", "Pipfile"}, []string{"Python"},
+		[]string{".venv", "venv", "__pycache__", ".tox"}},
+	{"maven", []string{"pom.xml"}, []string{"Java"}, []string{"target"}},
+	{"gradle", []string{"build.gradle", "build.gradle.kts"}, []string{"Java", "Kotlin"}, []string{"build", ".gradle"}},
+	{"php", []string{"composer.json"}, []string{"PHP"}, []string{"vendor"}},
+}
+
+// detectEcosystems returns the ecosystems of the project at root
+func detectEcosystems(root string) []ecosystem {
+	found := []ecosystem{}
+	for _, e := range ecosystems {
+		for _, marker := range e.markers {
+			if utils.FileExists(filepath.Join(root, marker)) {
+				found = append(found, e)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// projectMarkers returns the files that identify root as a project
+func projectMarkers(root string) []string {
+	markers := []string{}
+	for _, marker := range append([]string{".git"}, allMarkers()...) {
+		if utils.FileExists(filepath.Join(root, marker)) {
+			markers = append(markers, marker)
+		}
+	}
+	return markers
+}
+
+func allMarkers() []string {
+	markers := []string{}
+	for _, e := range ecosystems {
+		markers = append(markers, e.markers...)
+	}
+	return markers
+}
+
+// ctagsProbe holds what is known about the installed indexer
+type ctagsProbe struct {
+	// empty if no indexer was found
+	Program string
+	Version string
+	Flavour string
+	// the supported languages (nil if unknown)
+	Languages []string
+	// whether it can output json
+	JSON bool
+}
+
+// probeCtags looks for ctags (or etags) in PATH and the languages it
+// supports
+func probeCtags() ctagsProbe {
+	for _, name := range []string{"ctags", "etags"} {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			continue
+		}
+		probe := ctagsProbe{Program: path}
+		features, _ := indexers.DetectFeatures(path)
+		probe.Flavour, probe.Version, probe.JSON = features.Flavour, features.Version, features.Has("json")
+		if probe.Flavour == indexers.FlavourUniversal || probe.Flavour == indexers.FlavourExuberant {
+			if out, err := utils.ExecInPath(path, []string{"--list-languages"}, "."); err == nil {
+				for _, line := range strings.Split(string(out), "\n") {
+					// universal ctags marks disabled languages
+					if fields := strings.Fields(line); len(fields) == 1 {
+						probe.Languages = append(probe.Languages, fields[0])
+					}
+				}
+			}
+		}
+		return probe
+	}
+	return ctagsProbe{}
+}
+
+// init [--print] [--force] [dir]
+// generates a config for the projects in dir (or for dir itself)
+func initCommand(configFilePath string, args []string, out io.Writer) error {
+	flags, _ := newFlagSet("init")
+	printOnly := flags.Bool("print", false, "Print the config instead of writing it")
+	force := flags.Bool("force", false, "Overwrite an existing config file")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	root := "."
+	if flags.NArg() == 1 {
+		root = flags.Arg(0)
+	}
+	root, err := filepath.Abs(utils.Canonicalize(root))
+	if err != nil {
+		return err
+	}
+	if dir, err := utils.IsDirectory(root); err != nil || !dir {
+		return fmt.Errorf("%s is not a directory", root)
+	}
+	contents := generateConfig(root, probeCtags())
+	if *printOnly {
+		_, err := out.Write(contents)
+		return err
+	}
+	if utils.FileExists(configFilePath) && !*force {
+		return fmt.Errorf("%s already exists (use --force to overwrite it or --print to inspect the generated config)",
+			configFilePath)
+	}
+	if err := os.MkdirAll(filepath.Dir(configFilePath), 0755); err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(configFilePath, contents, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Wrote %s (see tagger config check)\n", configFilePath)
+	return nil
+}
+
+// generateConfig returns a commented config for root: if root is not a
+// project itself, it is configured as a workspace of the projects found
+// in its subdirectories
+func generateConfig(root string, probe ctagsProbe) []byte {
+	projects := []string{root}
+	markers := projectMarkers(root)
+	workspace := len(markers) == 0
+	if workspace {
+		projects = []string{}
+		entries, _ := ioutil.ReadDir(root)
+		found := utils.NewSet([]string{})
+		for _, entry := range entries {
+			dir := filepath.Join(root, entry.Name())
+			if isDir, err := utils.IsDirectory(dir); err != nil || !isDir {
+				continue
+			}
+			if m := projectMarkers(dir); len(m) > 0 {
+				projects = append(projects, dir)
+				found.AddAll(utils.NewSet(m))
+			}
+		}
+		markers = found.Elements()
+	}
+	// collect the ecosystems of all projects
+	names := utils.NewSet([]string{})
+	languages := utils.NewSet([]string{})
+	excludes := []string{".git"}
+	comments := map[string]string{}
+	for _, project := range projects {
+		for _, e := range detectEcosystems(project) {
+			if names.Has(e.name) {
+				continue
+			}
+			names.Add(e.name)
+			languages.AddAll(utils.NewSet(e.languages))
+			for _, exclude := range e.excludes {
+				if _, ok := comments[exclude]; !ok && exclude != ".git" {
+					comments[exclude] = e.name
+					excludes = append(excludes, exclude)
+				}
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# generated by tagger init for %s\n", root)
+	if names.Len() > 0 {
+		fmt.Fprintf(&b, "# detected: %s\n", strings.Join(names.Elements(), ", "))
+	}
+	b.WriteString("indexer:\n")
+	switch {
+	case probe.Program == "":
+		b.WriteString("  # ctags was not found in PATH: install Universal Ctags\n")
+		b.WriteString("  program: ctags\n")
+	case probe.Version != "":
+		fmt.Fprintf(&b, "  # %s\n", probe.Version)
+		fmt.Fprintf(&b, "  program: %s\n", probe.Program)
+	default:
+		fmt.Fprintf(&b, "  program: %s\n", probe.Program)
+	}
+	b.WriteString("  args:\n")
+	if probe.Flavour == indexers.FlavourEtags {
+		b.WriteString("    # etags does not recurse into directories, so tagger lists the files of the projects\n")
+	} else {
+		b.WriteString("    - -R\n")
+		b.WriteString("    - -e\n")
+	}
+	if supported := supportedLanguages(languages.Elements(), probe.Languages); len(supported) > 0 {
+		b.WriteString("    # uncomment to index only the detected languages\n")
+		fmt.Fprintf(&b, "    # - --languages=%s\n", strings.Join(supported, ","))
+	}
+	fmt.Fprintf(&b, "  tag_file: %s\n", indexers.TagFileName)
+	b.WriteString("  # directories (names or absolute paths) that are not indexed or watched\n")
+	b.WriteString("  exclude:\n")
+	for _, exclude := range excludes {
+		if ecosystem, ok := comments[exclude]; ok {
+			fmt.Fprintf(&b, "    - %s # %s\n", exclude, ecosystem)
+		} else {
+			fmt.Fprintf(&b, "    - %s\n", exclude)
+		}
+	}
+	if probe.JSON {
+		b.WriteString("  # uncomment to build the tag file (and the symbol index) from structured tags\n")
+		b.WriteString("  # output: json\n")
+	}
+	b.WriteString("  # projects are reindexed at most once per period\n")
+	b.WriteString("  max_period: 2s\n")
+	if workspace {
+		b.WriteString("# every directory that matches the glob and contains one of the markers\n")
+		b.WriteString("# is monitored as a project\n")
+		b.WriteString("workspaces:\n")
+		fmt.Fprintf(&b, "  - glob: %s\n", filepath.Join(root, "*"))
+		if len(markers) == 0 {
+			markers = DefaultMarkers
+		}
+		b.WriteString("    markers:\n")
+		for _, marker := range markers {
+			fmt.Fprintf(&b, "      - %s\n", marker)
+		}
+		if len(projects) > 0 {
+			b.WriteString("# found:\n")
+			for _, project := range projects {
+				fmt.Fprintf(&b, "#   %s\n", project)
+			}
+		}
+	} else {
+		b.WriteString("projects:\n")
+		fmt.Fprintf(&b, "  - path: %s\n", root)
+	}
+	return b.Bytes()
+}
+
+// supportedLanguages returns the (sorted) languages that the indexer
+// supports; all of them if its languages are unknown
+func supportedLanguages(languages []string, supported []string) []string {
+	if supported == nil {
+		return languages
+	}
+	set := utils.NewSet([]string{})
+	for _, language := range supported {
+		set.Add(strings.ToLower(language))
+	}
+	result := []string{}
+	for _, language := range languages {
+		if set.Has(strings.ToLower(language)) {
+			result = append(result, language)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
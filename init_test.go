@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_generateConfig_Workspace(t *testing.T) {
+	root, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+	for _, file := range []string{"web/package.json", "api/go.mod", "api/.git/HEAD", "ml/pyproject.toml",
+		"notes/README"} {
+		path := filepath.Join(root, file)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		TouchFile(t, path)
+	}
+	probe := ctagsProbe{Program: "/usr/bin/ctags", Version: "Universal Ctags 5.9.0",
+		Flavour: "universal", Languages: []string{"Go", "JavaScript", "Python"}}
+
+	contents := generateConfig(root, probe)
+	config := filepath.Join(root, "tagger.yml")
+	WriteConfig(t, config, string(contents))
+	c, err := LoadConfig(config)
+	assert.Nil(t, err)
+	assert.Equal(t, "/usr/bin/ctags", c.Indexer.Program)
+	assert.Equal(t, []string{"-R", "-e"}, c.Indexer.Args)
+	assert.Equal(t, []string{".git", "vendor", ".venv", "venv", "__pycache__", ".tox", "node_modules", "dist"},
+		c.Indexer.ExcludeDirs)
+	assert.Empty(t, c.Projects)
+	assert.Len(t, c.Workspaces, 1)
+	assert.Equal(t, filepath.Join(root, "*"), c.Workspaces[0].Glob)
+	assert.Equal(t, []string{".git", "go.mod", "package.json", "pyproject.toml"}, c.Workspaces[0].Markers)
+	// TypeScript is not supported by the indexer
+	assert.Contains(t, string(contents), "# - --languages=Go,JavaScript,Python\n")
+	assert.Contains(t, string(contents), "#   "+filepath.Join(root, "api")+"\n")
+	assert.NotContains(t, string(contents), filepath.Join(root, "notes"))
+}
+
+func Test_generateConfig_Project(t *testing.T) {
+	root, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+	TouchFile(t, filepath.Join(root, "Cargo.toml"))
+
+	contents := generateConfig(root, ctagsProbe{})
+	config := filepath.Join(root, "tagger.yml")
+	WriteConfig(t, config, string(contents))
+	c, err := LoadConfig(config)
+	assert.Nil(t, err)
+	assert.Equal(t, "ctags", c.Indexer.Program)
+	assert.Equal(t, []string{".git", "target"}, c.Indexer.ExcludeDirs)
+	assert.Len(t, c.Projects, 1)
+	assert.Equal(t, root, c.Projects[0].Path)
+	assert.Empty(t, c.Workspaces)
+	assert.Contains(t, string(contents), "ctags was not found")
+	assert.Contains(t, string(contents), "# - --languages=Rust\n")
+}
+
+func Test_initCommand_DoesNotOverwriteConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+	config := filepath.Join(root, "config", "tagger.yml")
+
+	var out bytes.Buffer
+	assert.Nil(t, initCommand(config, []string{"--print", root}, &out))
+	assert.True(t, strings.HasPrefix(out.String(), "# generated by tagger init for "+root))
+	_, err = os.Stat(config)
+	assert.True(t, os.IsNotExist(err))
+
+	out.Reset()
+	assert.Nil(t, initCommand(config, []string{root}, &out))
+	assert.Equal(t, "Wrote "+config+" (see tagger config check)\n", out.String())
+	assert.Error(t, initCommand(config, []string{root}, &out))
+	assert.Nil(t, initCommand(config, []string{"--force", root}, &out))
+
+	assert.Error(t, initCommand(config, []string{filepath.Join(root, "missing")}, &out))
+	assert.Equal(t, errUsage, initCommand(config, []string{root, root}, &out))
+}
@@ -1,22 +1,44 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-var DefaultConfigFilePath string = filepath.Join(os.Getenv("HOME"), ".tagger.yml")
+const (
+	ExitOK = iota
+	// the http server failed
+	ExitServerError
+	// in-flight indexing did not finish in time and was aborted
+	ExitShutdownTimeout
+	// a client command failed
+	ExitCommandError
+	// invalid command line
+	ExitUsage
+	// the config file is invalid
+	ExitConfigError
+)
 
 func main() {
 
 	log.SetFormatter(&log.TextFormatter{})
 
 	// parse command line args
-	configFilePath := flag.String("c", DefaultConfigFilePath, "Path to config file")
+	configFilePath := flag.String("c", DefaultConfigFilePath(), "Path to config file")
 	debug := flag.Bool("d", false, "Activate debug logging level")
+	stateFilePath := flag.String("s", DefaultStateFilePath(),
+		"Path to the file where runtime project changes are stored (the api token is kept next to it)")
+	shutdownTimeout := flag.Duration("t", 10*time.Second,
+		"Time to wait for in-flight indexing on shutdown before aborting it (daemon)")
+	profiling := flag.Bool("pprof", false, "Serve the pprof endpoints under /debug/pprof/ (daemon)")
+	flag.Usage = usage
 	flag.Parse()
 
 	if *debug {
@@ -25,13 +47,206 @@ func main() {
 		log.SetLevel(log.InfoLevel)
 	}
 
+	// the daemon is run if no command is given
+	args := flag.Args()
+	if len(args) > 0 && args[0] != "daemon" {
+		os.Exit(runCommand(*configFilePath, *stateFilePath, args, os.Stdout))
+	}
+	if len(args) > 0 {
+		// the daemon options may also follow the command
+		flag.CommandLine.Parse(args[1:])
+		if flag.NArg() > 0 {
+			usage()
+			os.Exit(ExitUsage)
+		}
+	}
+	os.Exit(daemon(*configFilePath, *stateFilePath, *shutdownTimeout, *profiling))
+}
+
+// daemon monitors the configured projects and serves the api until it is
+// signalled to stop; the process exit status is returned
+func daemon(configFilePath string, stateFilePath string, shutdownTimeout time.Duration, profiling bool) int {
 	// parse config
-	config := NewConfig(*configFilePath)
+	config, err := LoadConfig(configFilePath)
+	if err != nil {
+		log.Error(err)
+		return ExitConfigError
+	}
+	config.stateFilePath = stateFilePath
+	// the token is resolved before anything is started
+	token := ""
+	if config.RequiresToken() {
+		if token, err = config.Token(); err != nil {
+			log.Error("Unable to set up the api token: ", err)
+			return ExitConfigError
+		}
+	}
+	// merge runtime changes from previous sessions
+	state := loadState(config, stateFilePath, configFilePath)
 	// create project manager
-	manager := NewManager(config.Indexer, config.Projects)
-	// create server
-	server := &Server{Manager: manager, Port: config.Port}
-	go server.Listen()
+	manager := NewManager(config.Indexer, []struct{ Path string }{})
+	manager.Nesting = nestingPolicy(config)
+	manager.Reload(config.Indexer, state.Merge(config.Projects))
+	for _, path := range manager.Paths() {
+		if state.IsPaused(path) {
+			manager.Pause(path)
+		}
+	}
+	manager.State = state
 	// start monitoring
-	manager.Start()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager.Start(ctx)
+	stopWorkspaces := watchWorkspaces(ctx, manager, config.Workspaces)
+	// create server
+	server := NewServer(manager, config.Listener(), token)
+	server.Profiling = profiling
+	failed := make(chan error, 1)
+	go func() { failed <- server.Listen() }()
+
+	// reload the config when it changes on disk or on SIGHUP
+	configChanges, stopWatching := watchConfig(ctx, config)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	reloadConfig := func() {
+		files := config.Files()
+		config = reload(manager, state, config, configFilePath)
+		stopWorkspaces()
+		stopWorkspaces = watchWorkspaces(ctx, manager, config.Workspaces)
+		// the included files may have changed
+		if !reflect.DeepEqual(files, config.Files()) {
+			stopWatching()
+			configChanges, stopWatching = watchConfig(ctx, config)
+		}
+	}
+
+	status := ExitOK
+loop:
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reloadConfig()
+				continue
+			}
+			log.Infof("Received %s -- shutting down", sig)
+			break loop
+		case <-configChanges:
+			reloadConfig()
+		case err := <-failed:
+			log.Error("Server failed: ", err)
+			status = ExitServerError
+			break loop
+		}
+	}
+	signal.Reset(syscall.SIGHUP)
+	stopWorkspaces()
+	// a second signal forces immediate termination
+	go func() {
+		sig := <-signals
+		log.Warnf("Received %s -- exiting immediately", sig)
+		os.Exit(ExitShutdownTimeout)
+	}()
+
+	return shutdown(server, manager, shutdownTimeout, status)
+}
+
+// reload re-reads the config file and applies it to the manager; an
+// invalid config is reported and otherwise ignored. The config in effect
+// is returned.
+func reload(manager *Manager, state *State, current *Config, configFilePath string) *Config {
+	log.Info("Reloading ", configFilePath)
+	config, err := LoadConfig(configFilePath)
+	if err != nil {
+		log.Error("Reload failed: ", err)
+		return current
+	}
+	config.stateFilePath = current.stateFilePath
+	if config.Listener() != current.Listener() || config.Auth != current.Auth {
+		log.Warn("Changing the listen or auth settings requires a restart")
+	}
+	if config.Persist != current.Persist {
+		log.Warn("Changing the persistence mode requires a restart")
+	}
+	if config.Nesting != current.Nesting {
+		log.Warn("Changing the nesting policy requires a restart")
+	}
+	manager.Reload(config.Indexer, state.Merge(config.Projects))
+	return config
+}
+
+// watchConfig watches the files of config and returns a function that
+// stops watching them
+func watchConfig(ctx context.Context, config *Config) (<-chan struct{}, func()) {
+	wctx, cancel := context.WithCancel(ctx)
+	changes, err := WatchConfig(wctx, config.Files()...)
+	if err != nil {
+		log.Error("Unable to watch config file: ", err)
+	}
+	return changes, cancel
+}
+
+// watchWorkspaces discovers projects in the background and returns a
+// function that stops the discovery
+func watchWorkspaces(ctx context.Context, manager *Manager, workspaces []Workspace) func() {
+	wctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	watcher := &WorkspaceWatcher{Workspaces: workspaces, Manager: manager}
+	go func() {
+		watcher.Watch(wctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func nestingPolicy(config *Config) string {
+	switch config.Nesting {
+	case "":
+		return NestingReject
+	case NestingReject, NestingSubproject, NestingMerge:
+		return config.Nesting
+	default:
+		log.Fatalf("Invalid nesting value %q (use %q, %q or %q)", config.Nesting,
+			NestingReject, NestingSubproject, NestingMerge)
+		return ""
+	}
+}
+
+func loadState(config *Config, stateFilePath string, configFilePath string) *State {
+	switch config.Persist {
+	case "", PersistToState:
+		configFilePath = ""
+	case PersistToConfig:
+	default:
+		log.Fatalf("Invalid persist value %q (use %q or %q)",
+			config.Persist, PersistToState, PersistToConfig)
+	}
+	state, err := LoadState(stateFilePath, configFilePath)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	return state
+}
+
+// shutdown stops the server and all projects within timeout and
+// returns the process exit status
+func shutdown(server *Server, manager *Manager, timeout time.Duration, status int) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error("Server shutdown: ", err)
+	}
+	if err := manager.Shutdown(ctx); err != nil {
+		log.Error("Project shutdown: ", err)
+		if status == ExitOK {
+			status = ExitShutdownTimeout
+		}
+	}
+	log.Info("Bye")
+	return status
 }
@@ -2,28 +2,143 @@ package main
 
 import (
 	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/kkentzo/tagger/indexers"
+	"github.com/kkentzo/tagger/metrics"
+	"github.com/kkentzo/tagger/tags"
 	"github.com/kkentzo/tagger/utils"
+	"github.com/kkentzo/tagger/watchers"
 	log "github.com/sirupsen/logrus"
 )
 
+// how often the roots of all projects are checked for existence
+var RootCheckPeriod = 10 * time.Second
+
+// how long Shutdown waits for projects to return after aborting their
+// indexing processes
+var AbortGracePeriod = time.Second
+
+var (
+	ErrInvalidPath     = errors.New("path does not exist or is not a directory")
+	ErrRelativePath    = errors.New("path must be absolute")
+	ErrProjectNotFound = errors.New("project not found")
+	ErrProjectExists   = errors.New("project already exists")
+	ErrProjectNested   = errors.New("project overlaps with an existing project")
+	ErrProjectCovered  = errors.New("project is merged into an enclosing project")
+	ErrManagerStopped  = errors.New("manager has been shut down")
+	ErrProjectInactive = errors.New("project is not being monitored")
+)
+
+// policies for projects that are nested within other projects
+const (
+	// nested projects are not allowed
+	NestingReject = "reject"
+	// nested projects are excluded from the index of the enclosing
+	// project, which references them through etags include entries
+	NestingSubproject = "subproject"
+	// nested projects are covered by the enclosing project
+	NestingMerge = "merge"
+)
+
 type ProjectWithContext struct {
 	Project Monitorable
 	Cancel  context.CancelFunc
+	Paused  bool
+	// whether the project root has disappeared from the filesystem
+	Missing bool
+	// whether the project was found in a workspace (as opposed to being
+	// configured or added at runtime)
+	Discovered bool
+	// whether Project has already been monitored (and thus consumed)
+	launched bool
+	// shared by all the incarnations of Project
+	status *Status
+	// closed when the monitor of the latest incarnation returns
+	done chan struct{}
+	// kills the indexing run of the latest incarnation
+	abort context.CancelFunc
+}
+
+// ProjectInfo is a snapshot of a project's registration
+type ProjectInfo struct {
+	ID         string `json:"id"`
+	Path       string `json:"path"`
+	Paused     bool   `json:"paused"`
+	Missing    bool   `json:"missing"`
+	Discovered bool   `json:"discovered"`
+	// one of the State* constants
+	State   string `json:"state"`
+	LastRun *Run   `json:"last_run,omitempty"`
+}
+
+// ReindexOptions are the options of a manual reindex request
+type ReindexOptions struct {
+	// ignore any cached state and reindex the dependencies too
+	Full bool `json:"full"`
+	// reindex the dependencies even if they have not changed
+	Dependencies bool `json:"dependencies"`
+}
+
+func (options ReindexOptions) event() watchers.Event {
+	event := watchers.NewEvent()
+	event.Full = options.Full
+	event.Dependencies = options.Dependencies
+	return event
 }
 
+// ProjectStatus extends ProjectInfo with the indexing history and the
+// project's tag files
+type ProjectStatus struct {
+	ProjectInfo
+	// most recent first
+	Runs     []Run         `json:"runs"`
+	TagFiles []TagFileInfo `json:"tag_files"`
+	// the symbols held in memory (if loaded)
+	Symbols *tags.Stats `json:"symbols,omitempty"`
+}
+
+// Manager keeps the registry of monitored projects. All of its methods
+// are safe for concurrent use (e.g. from http handlers).
 type Manager struct {
 	indexer  indexers.Indexable
 	projects map[string]*ProjectWithContext
 	pg       sync.WaitGroup
+	mu       sync.RWMutex
+	// ctx is the parent of all project contexts; nil until Start() is called
+	ctx     context.Context
+	stopped bool
+	// abort is shared by all projects; cancelling it kills indexing runs
+	abort  context.Context
+	cancel context.CancelFunc
+	// if set, runtime changes to the project set are recorded here
+	State *State
+	// the projects currently found in workspaces
+	discovered map[string]bool
+	// the policy for nested projects (see Nesting* constants)
+	Nesting string
+	// receives the events of all projects
+	Events *Broker
+	// the symbols of all projects
+	Tags *tags.DB
 }
 
 func NewManager(indexer indexers.Indexable, projects []struct{ Path string }) *Manager {
+	abort, cancel := context.WithCancel(context.Background())
 	manager := &Manager{
-		indexer:  indexer,
-		projects: make(map[string]*ProjectWithContext),
+		indexer:    indexer,
+		projects:   make(map[string]*ProjectWithContext),
+		discovered: make(map[string]bool),
+		abort:      abort,
+		cancel:     cancel,
+		Events:     NewBroker(),
+		Tags:       tags.NewDB(),
 	}
 	for _, p := range projects {
 		manager.Add(p.Path)
@@ -31,51 +146,698 @@ func NewManager(indexer indexers.Indexable, projects []struct{ Path string }) *M
 	return manager
 }
 
-func (manager *Manager) Add(path string) {
-	path = utils.Canonicalize(path)
+// Start launches the monitoring of all registered projects and returns
+// immediately; projects added afterwards are monitored as soon as they
+// are added. Cancelling ctx stops all projects.
+func (manager *Manager) Start(ctx context.Context) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if manager.ctx != nil || manager.stopped {
+		return
+	}
+	manager.ctx = ctx
+	for path, project := range manager.projects {
+		manager.run(path, project)
+	}
+	go manager.checkRoots(ctx)
+}
+
+// Shutdown stops all projects and waits for their in-flight indexing to
+// finish; if ctx expires first, running indexing processes are killed
+// and ctx's error is returned
+func (manager *Manager) Shutdown(ctx context.Context) error {
+	manager.mu.Lock()
+	manager.stopped = true
+	for _, project := range manager.projects {
+		if project.Cancel != nil {
+			project.Cancel()
+			project.Cancel = nil
+		}
+	}
+	manager.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		manager.pg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	log.Warn("Shutdown timed out -- aborting running indexers")
+	manager.cancel()
+	select {
+	case <-done:
+	case <-time.After(AbortGracePeriod):
+	}
+	return ctx.Err()
+}
+
+// projectPath returns the key of the project at path in the registry
+// (so that e.g. a trailing slash does not make a different project)
+func projectPath(path string) string {
+	return filepath.Clean(utils.Canonicalize(path))
+}
+
+// Add starts monitoring the project at path (which must be an absolute
+// path to a directory)
+func (manager *Manager) Add(path string) error {
+	path = projectPath(path)
+	// relative paths would be resolved against the working directory
+	if !filepath.IsAbs(path) {
+		return ErrRelativePath
+	}
 	// skip non-existent path
-	if !utils.FileExists(path) {
+	if isDir, err := utils.IsDirectory(path); err != nil || !isDir {
 		log.Debugf("Path %s does not exist in filesystem", path)
-		return
+		return ErrInvalidPath
+	}
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	err := manager.add(path, false)
+	// an explicit addition of a discovered project makes it permanent
+	if project, ok := manager.projects[path]; ok && project.Discovered {
+		project.Discovered = false
+		err = nil
+	}
+	if err != nil {
+		log.Infof("Not adding %s: %s", path, err)
+		return err
+	}
+	if manager.State != nil {
+		manager.persist(manager.State.RecordAdd(path))
+	}
+	return nil
+}
+
+func (manager *Manager) Remove(path string) error {
+	path = projectPath(path)
+	// what happens if path does not exist?
+	// This is legit in case the project root is deleted from the fs
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if !manager.remove(path) {
+		return ErrProjectNotFound
+	}
+	if manager.State != nil {
+		manager.persist(manager.State.RecordRemove(path))
+	}
+	return nil
+}
+
+// Reload brings the manager in line with a (re-read) configuration:
+// projects no longer listed are removed, new ones are added and, if the
+// indexer settings changed, existing projects are restarted
+func (manager *Manager) Reload(indexer indexers.Indexable, projects []struct{ Path string }) {
+	wanted := make(map[string]bool)
+	paths := []string{}
+	for _, p := range projects {
+		path := projectPath(p.Path)
+		if !filepath.IsAbs(path) {
+			log.Warnf("Not adding %s: %s", path, ErrRelativePath)
+			continue
+		}
+		wanted[path] = true
+		paths = append(paths, path)
 	}
-	if manager.Exists(path) {
-		log.Debugf("Path %s already monitored", path)
+	// enclosing projects are added before the nested ones
+	sort.Strings(paths)
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if manager.stopped {
 		return
 	}
-	if _, ok := manager.projects[path]; !ok {
-		project := &Project{
-			Path:    path,
-			Indexer: manager.indexer.Create(path),
-			Watcher: manager.indexer.CreateWatcher(path),
+	changed := !reflect.DeepEqual(manager.indexer, indexer)
+	manager.indexer = indexer
+	for path, project := range manager.projects {
+		if !wanted[path] && manager.discovered[path] {
+			project.Discovered = true
+		} else if wanted[path] {
+			project.Discovered = false
+		}
+		if !wanted[path] && !project.Discovered {
+			log.Info("Removing ", path)
+			manager.remove(path)
+		} else if changed {
+			log.Info("Restarting ", path)
+			manager.restart(path)
+		}
+	}
+	for _, path := range paths {
+		if _, ok := manager.projects[path]; ok {
+			continue
 		}
-		ctx, cancel := context.WithCancel(context.Background())
-		manager.projects[path] = &ProjectWithContext{
-			Project: project,
-			Cancel:  cancel,
+		// a missing project is resumed once its root appears
+		missing := !utils.FileExists(path)
+		if missing {
+			log.Warnf("Adding %s (root is missing)", path)
+		} else {
+			log.Info("Adding ", path)
+		}
+		if err := manager.add(path, missing); err != nil {
+			log.Infof("Not adding %s: %s", path, err)
 		}
-		manager.pg.Add(1)
-		go project.Monitor(ctx)
 	}
 }
 
-func (manager *Manager) Remove(path string) {
-	path = utils.Canonicalize(path)
-	// what happens if path does not exist?
-	// This is legit in case the project root is deleted from the fs
-	if project, ok := manager.projects[path]; ok {
-		// Send cancellation signal to project
+// SetDiscovered replaces the set of projects found in workspaces: new
+// projects are added (unless they were explicitly removed at runtime) and
+// discovered projects that are no longer present are removed
+func (manager *Manager) SetDiscovered(paths []string) {
+	discovered := make(map[string]bool)
+	for i, path := range paths {
+		paths[i] = projectPath(path)
+		discovered[paths[i]] = true
+	}
+	sort.Strings(paths)
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.discovered = discovered
+	for path, project := range manager.projects {
+		if project.Discovered && !discovered[path] {
+			log.Info("Workspace: removing ", path)
+			manager.remove(path)
+		}
+	}
+	for _, path := range paths {
+		if _, ok := manager.projects[path]; ok {
+			continue
+		}
+		if manager.State != nil && manager.State.IsRemoved(path) {
+			continue
+		}
+		log.Info("Workspace: adding ", path)
+		if err := manager.add(path, false); err != nil {
+			log.Infof("Not adding %s: %s", path, err)
+		} else {
+			manager.projects[path].Discovered = true
+		}
+	}
+}
+
+// Pause stops monitoring the project at path but keeps it registered
+func (manager *Manager) Pause(path string) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	path = projectPath(path)
+	project, ok := manager.projects[path]
+	if !ok {
+		return ErrProjectNotFound
+	}
+	if project.Cancel != nil {
 		project.Cancel()
-		// remove project from registry
-		delete(manager.projects, path)
-		manager.pg.Done()
+		project.Cancel = nil
+	}
+	project.Paused = true
+	if manager.State != nil {
+		manager.persist(manager.State.RecordPause(path, true))
+	}
+	return nil
+}
+
+// Resume restarts the monitoring of a paused project
+func (manager *Manager) Resume(path string) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if manager.stopped {
+		return ErrManagerStopped
 	}
+	path = projectPath(path)
+	project, ok := manager.projects[path]
+	if !ok {
+		return ErrProjectNotFound
+	}
+	if !project.Paused {
+		return nil
+	}
+	project.Paused = false
+	if manager.State != nil {
+		manager.persist(manager.State.RecordPause(path, false))
+	}
+	manager.run(path, project)
+	return nil
 }
 
 func (manager *Manager) Exists(path string) bool {
-	_, ok := manager.projects[utils.Canonicalize(path)]
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	_, ok := manager.projects[projectPath(path)]
 	return ok
 }
 
-func (manager *Manager) Start() {
-	manager.pg.Wait()
+// Started returns true if Start has been called (and Shutdown has not)
+func (manager *Manager) Started() bool {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	return manager.ctx != nil && !manager.stopped
+}
+
+// Pending returns the (sorted) paths of the monitored projects that have
+// not completed their initial indexing yet (regardless of its outcome)
+func (manager *Manager) Pending() []string {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	pending := []string{}
+	for path, project := range manager.projects {
+		if project.Paused || project.Missing {
+			continue
+		}
+		if project.status.LastRun() == nil {
+			pending = append(pending, path)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// Paths returns the (sorted) paths of all registered projects
+func (manager *Manager) Paths() []string {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	paths := make([]string, 0, len(manager.projects))
+	for path := range manager.projects {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Projects returns the (sorted by path) snapshots of all projects
+func (manager *Manager) Projects() []ProjectInfo {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	projects := make([]ProjectInfo, 0, len(manager.projects))
+	for path, project := range manager.projects {
+		projects = append(projects, info(path, project))
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].Path < projects[j].Path })
+	return projects
+}
+
+// Find returns the snapshot of the project with the given id or path
+func (manager *Manager) Find(idOrPath string) (ProjectInfo, error) {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	path := projectPath(idOrPath)
+	if project, ok := manager.projects[path]; ok {
+		return info(path, project), nil
+	}
+	for path, project := range manager.projects {
+		if ProjectID(path) == idOrPath {
+			return info(path, project), nil
+		}
+	}
+	return ProjectInfo{}, ErrProjectNotFound
+}
+
+// Status returns the status of the project with the given id or path
+func (manager *Manager) Status(idOrPath string) (ProjectStatus, error) {
+	info, err := manager.Find(idOrPath)
+	if err != nil {
+		return ProjectStatus{}, err
+	}
+	manager.mu.RLock()
+	project, ok := manager.projects[info.Path]
+	var tagFiles []string
+	if ok {
+		if p, isProject := project.Project.(*Project); isProject {
+			if tf, isTagFileable := p.Indexer.(indexers.TagFileable); isTagFileable {
+				tagFiles = tf.TagFiles(info.Path)
+			}
+		}
+	}
+	manager.mu.RUnlock()
+	if !ok {
+		return ProjectStatus{}, ErrProjectNotFound
+	}
+	status := ProjectStatus{
+		ProjectInfo: info,
+		Runs:        project.status.Runs(),
+		TagFiles:    []TagFileInfo{},
+	}
+	// tag files are read outside the lock
+	for _, path := range tagFiles {
+		status.TagFiles = append(status.TagFiles, project.status.TagFile(path))
+	}
+	if stats, ok := manager.Tags.Stats(info.Path); ok {
+		status.Symbols = &stats
+	}
+	return status, nil
+}
+
+// Resolve returns the status of the innermost project that contains
+// file (an absolute path)
+func (manager *Manager) Resolve(file string) (ProjectStatus, error) {
+	file = filepath.Clean(utils.Canonicalize(file))
+	manager.mu.RLock()
+	owner := manager.parentOf(file)
+	if _, ok := manager.projects[file]; ok {
+		owner = file
+	}
+	manager.mu.RUnlock()
+	if owner == "" {
+		return ProjectStatus{}, ErrProjectNotFound
+	}
+	return manager.Status(owner)
+}
+
+// Reindex queues an indexing run for the project with the given id or
+// path; the project must be actively monitored
+func (manager *Manager) Reindex(idOrPath string, options ReindexOptions) error {
+	info, err := manager.Find(idOrPath)
+	if err != nil {
+		return err
+	}
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	project, ok := manager.projects[info.Path]
+	if !ok {
+		return ErrProjectNotFound
+	}
+	if project.Cancel == nil {
+		return ErrProjectInactive
+	}
+	log.Info("Reindex requested for ", info.Path)
+	project.Project.Reindex(options.event())
+	return nil
+}
+
+// ReindexAll queues an indexing run for every actively monitored project
+// and returns the (sorted by path) projects that will be reindexed
+func (manager *Manager) ReindexAll(options ReindexOptions) []ProjectInfo {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	projects := []ProjectInfo{}
+	for path, project := range manager.projects {
+		if project.Cancel == nil {
+			continue
+		}
+		project.Project.Reindex(options.event())
+		projects = append(projects, info(path, project))
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].Path < projects[j].Path })
+	return projects
+}
+
+func info(path string, project *ProjectWithContext) ProjectInfo {
+	state := project.status.State()
+	if project.Missing {
+		state = StateMissing
+	} else if project.Paused {
+		state = StatePaused
+	}
+	return ProjectInfo{
+		ID:         ProjectID(path),
+		Path:       path,
+		Paused:     project.Paused,
+		Missing:    project.Missing,
+		Discovered: project.Discovered,
+		State:      state,
+		LastRun:    project.status.LastRun(),
+	}
+}
+
+// the following methods must be called with the lock held
+
+// add registers the project at path and launches it unless its root is
+// missing
+func (manager *Manager) add(path string, missing bool) error {
+	if manager.stopped {
+		return ErrManagerStopped
+	}
+	if _, ok := manager.projects[path]; ok {
+		return ErrProjectExists
+	}
+	parent := manager.parentOf(path)
+	children := manager.childrenOf(path)
+	if parent != "" || len(children) > 0 {
+		switch manager.Nesting {
+		case NestingSubproject:
+		case NestingMerge:
+			if parent != "" {
+				return ErrProjectCovered
+			}
+			for _, child := range children {
+				log.Infof("Merging %s into %s", child, path)
+				manager.remove(child)
+			}
+		default:
+			return ErrProjectNested
+		}
+	}
+	status := NewStatus()
+	project := &ProjectWithContext{Project: manager.createProject(path, status), status: status, Missing: missing}
+	manager.projects[path] = project
+	manager.Events.Publish(NewProjectEvent(EventProjectAdded, path))
+	manager.run(path, project)
+	// the enclosing project must now exclude the new one
+	if manager.Nesting == NestingSubproject && parent != "" {
+		manager.restart(parent)
+	}
+	return nil
+}
+
+func (manager *Manager) remove(path string) bool {
+	project, ok := manager.projects[path]
+	if !ok {
+		return false
+	}
+	// Send cancellation signal to project
+	if project.Cancel != nil {
+		project.Cancel()
+	}
+	manager.discard(project)
+	// remove project from registry
+	delete(manager.projects, path)
+	manager.Events.Publish(NewProjectEvent(EventProjectRemoved, path))
+	metrics.Default.DeleteLabel("project", path)
+	manager.Tags.Remove(path)
+	// the enclosing project must now index the removed one
+	if manager.Nesting == NestingSubproject {
+		if parent := manager.parentOf(path); parent != "" {
+			manager.restart(parent)
+		}
+	}
+	return true
+}
+
+// parentOf returns the innermost project that encloses path (if any)
+func (manager *Manager) parentOf(path string) string {
+	parent := ""
+	for p := range manager.projects {
+		if isNested(path, p) && len(p) > len(parent) {
+			parent = p
+		}
+	}
+	return parent
+}
+
+// childrenOf returns the (sorted) projects that are nested in path
+func (manager *Manager) childrenOf(path string) []string {
+	children := []string{}
+	for p := range manager.projects {
+		if isNested(p, path) {
+			children = append(children, p)
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// subprojectsOf returns the outermost projects that are nested in path
+func (manager *Manager) subprojectsOf(path string) []string {
+	subprojects := []string{}
+	for _, child := range manager.childrenOf(path) {
+		// children are sorted, so enclosing ones come first
+		n := len(subprojects)
+		if n > 0 && isNested(child, subprojects[n-1]) {
+			continue
+		}
+		subprojects = append(subprojects, child)
+	}
+	return subprojects
+}
+
+// isNested returns true if path lies (strictly) within root
+func isNested(path string, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != "." && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// a failure to persist the state does not affect the running projects
+func (manager *Manager) persist(err error) {
+	if err != nil {
+		log.Error("Unable to persist project changes: ", err)
+	}
+}
+
+// restart replaces the project with a fresh one (picking up the current
+// indexer settings) and relaunches it unless it is paused
+func (manager *Manager) restart(path string) {
+	project := manager.projects[path]
+	// the run of the old incarnation uses stale settings
+	if project.abort != nil {
+		project.abort()
+	}
+	if project.Cancel != nil {
+		project.Cancel()
+		project.Cancel = nil
+	}
+	manager.discard(project)
+	project.Project = manager.createProject(path, project.status)
+	project.launched = false
+	manager.run(path, project)
+}
+
+func (manager *Manager) createProject(path string, status *Status) *Project {
+	indexer := manager.indexer
+	if manager.Nesting == NestingSubproject {
+		nestable, ok := indexer.(indexers.Nestable)
+		if subprojects := manager.subprojectsOf(path); ok && len(subprojects) > 0 {
+			indexer = nestable.WithSubprojects(subprojects)
+		}
+	}
+	return &Project{
+		Path:    path,
+		Indexer: indexer.Create(path),
+		Watcher: indexer.CreateWatcher(path),
+		Abort:   manager.abort,
+		Status:  status,
+		Events:  manager.Events,
+		Tags:    manager.Tags,
+	}
+}
+
+// discard releases the watcher of a project that was never monitored
+// (monitors close their watchers by themselves)
+func (manager *Manager) discard(project *ProjectWithContext) {
+	if p, ok := project.Project.(*Project); ok && !project.launched {
+		p.Watcher.Close()
+	}
+}
+
+// run launches the project if the manager has started (and has not been
+// shut down) and the project is neither paused nor missing
+func (manager *Manager) run(path string, project *ProjectWithContext) {
+	if manager.ctx != nil && !manager.stopped && !project.Paused && !project.Missing {
+		manager.launch(path, project)
+	}
+}
+
+// launch starts monitoring the project in its own goroutine. A project's watcher is closed when its monitor
+// returns, so a fresh project is created for every launch but the first. The new incarnation starts once the
+// previous one has returned, so that the latter's last run can not overwrite the former's tag files.
+func (manager *Manager) launch(path string, project *ProjectWithContext) {
+	if project.launched || project.Project == nil {
+		project.Project = manager.createProject(path, project.status)
+	}
+	project.launched = true
+	ctx, cancel := context.WithCancel(manager.ctx)
+	project.Cancel = cancel
+	abort, cancelAbort := context.WithCancel(manager.abort)
+	if p, ok := project.Project.(*Project); ok {
+		p.Abort = abort
+	}
+	project.abort = cancelAbort
+	previous := project.done
+	done := make(chan struct{})
+	project.done = done
+	manager.pg.Add(1)
+	go func(monitorable Monitorable) {
+		defer manager.pg.Done()
+		defer close(done)
+		defer cancelAbort()
+		if previous != nil {
+			<-previous
+		}
+		if ctx.Err() != nil {
+			// replaced (or stopped) while waiting
+			if p, ok := monitorable.(*Project); ok {
+				p.Watcher.Close()
+			}
+			return
+		}
+		monitorable.Monitor(ctx)
+		// a monitor that returns by itself has lost its root
+		if ctx.Err() == nil {
+			manager.setMissing(path, monitorable)
+		}
+		manager.forgetTags(path)
+	}(project.Project)
+}
+
+// setMissing marks the project as missing unless it has been replaced
+// (i.e. removed or restarted) in the meantime
+func (manager *Manager) setMissing(path string, monitorable Monitorable) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	project, ok := manager.projects[path]
+	if !ok || project.Project != monitorable {
+		return
+	}
+	manager.suspend(path, project)
+}
+
+// forgetTags drops the symbols of a project that has been removed (its
+// last indexing run may have reloaded them after the removal)
+func (manager *Manager) forgetTags(path string) {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	if _, ok := manager.projects[path]; !ok {
+		manager.Tags.Remove(path)
+	}
+}
+
+func (manager *Manager) suspend(path string, project *ProjectWithContext) {
+	log.Warnf("Suspending %s (root is missing)", path)
+	if project.Cancel != nil {
+		project.Cancel()
+		project.Cancel = nil
+	}
+	project.Missing = true
+}
+
+// checkRoots periodically suspends the projects whose root has
+// disappeared and resumes the ones whose root has reappeared
+func (manager *Manager) checkRoots(ctx context.Context) {
+	ticker := time.NewTicker(RootCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			manager.CheckRoots()
+		}
+	}
+}
+
+// CheckRoots updates the missing status of all projects
+func (manager *Manager) CheckRoots() {
+	// stat outside the lock; this may block on unresponsive mounts
+	exists := make(map[string]bool)
+	for _, path := range manager.Paths() {
+		exists[path] = utils.FileExists(path)
+	}
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if manager.stopped {
+		return
+	}
+	for path, found := range exists {
+		project, ok := manager.projects[path]
+		if !ok {
+			continue
+		}
+		if !found && !project.Missing {
+			manager.suspend(path, project)
+		} else if found && project.Missing {
+			log.Infof("Resuming %s (root has reappeared)", path)
+			project.Missing = false
+			manager.run(path, project)
+		}
+	}
 }
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/kkentzo/tagger/indexers"
+	"github.com/kkentzo/tagger/utils"
+	"github.com/kkentzo/tagger/watchers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -62,6 +70,33 @@ func Test_Manager_Add_WillAddProject_WhenPathExists(t *testing.T) {
 	assert.Contains(t, manager.projects, path)
 }
 
+func Test_Manager_Add_WillNormalizePath(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	assert.Nil(t, os.Mkdir(filepath.Join(path, "lib"), 0755))
+
+	indexer := &MockIndexer{}
+	indexer.On("Create", path).Return(indexer)
+	indexer.On("CreateWatcher", path).Return(CreateMockWatcher())
+	manager := NewManager(indexer, []struct{ Path string }{})
+
+	assert.Nil(t, manager.Add(path+"/"))
+	assert.Equal(t, []string{path}, manager.Paths())
+	assert.Equal(t, ErrProjectExists, manager.Add(path+"/lib/.."))
+	assert.Equal(t, ErrProjectNested, manager.Add(path+"/lib/"))
+	assert.True(t, manager.Exists(path+"/"))
+	assert.Nil(t, manager.Remove(path+"/"))
+	assert.Empty(t, manager.Paths())
+}
+
+func Test_Manager_Add_WillNotAddProject_WhenPathIsRelative(t *testing.T) {
+	manager := NewManager(&MockIndexer{}, []struct{ Path string }{})
+	assert.Equal(t, ErrRelativePath, manager.Add("."))
+	manager.Reload(&MockIndexer{}, []struct{ Path string }{{Path: "foo"}})
+	assert.Empty(t, manager.Paths())
+}
+
 func Test_Manager_Remove_WillRemoveProjectFromManager(t *testing.T) {
 	path, err := ioutil.TempDir("", "tagger-tests")
 	assert.Nil(t, err)
@@ -81,3 +116,536 @@ func Test_Manager_Remove_WillRemoveProjectFromManager(t *testing.T) {
 	manager.Remove(path)
 	assert.NotContains(t, manager.projects, path)
 }
+
+func Test_Manager_Start_WillMonitorProjects(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	watched := make(chan struct{}, 1)
+	watcher := &MockWatcher{}
+	watcher.On("Watch", mock.AnythingOfType("*context.cancelCtx")).
+		Run(func(args mock.Arguments) { watched <- struct{}{} })
+	watcher.On("Events")
+	watcher.On("Close")
+	indexer := &MockIndexer{}
+	indexer.On("Create", path).Return(indexer)
+	indexer.On("CreateWatcher", path).Return(watcher)
+	indexer.On("Index", path, mock.AnythingOfType("watchers.Event"))
+
+	manager := NewManager(indexer, []struct{ Path string }{{Path: path}})
+	manager.Start(context.Background())
+	<-watched
+	assert.Nil(t, manager.Shutdown(context.Background()))
+	watcher.AssertCalled(t, "Close")
+}
+
+func Test_Manager_Shutdown_WillReturnError_WhenContextExpires(t *testing.T) {
+	defer func(d time.Duration) { AbortGracePeriod = d }(AbortGracePeriod)
+	AbortGracePeriod = 10 * time.Millisecond
+
+	manager := NewManager(&MockIndexer{}, []struct{ Path string }{})
+	// simulate a monitor that never returns
+	manager.pg.Add(1)
+	defer manager.pg.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, manager.Shutdown(ctx))
+	// running indexers must have been aborted
+	assert.NotNil(t, manager.abort.Err())
+}
+
+func Test_Manager_Add_WillNotAddProject_AfterShutdown(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	manager := NewManager(&MockIndexer{}, []struct{ Path string }{})
+	assert.Nil(t, manager.Shutdown(context.Background()))
+	manager.Add(path)
+	assert.False(t, manager.Exists(path))
+}
+
+func Test_Manager_PauseAndResume(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	watched := make(chan struct{}, 2)
+	closed := make(chan struct{}, 2)
+	watcher := &MockWatcher{}
+	watcher.On("Watch", mock.AnythingOfType("*context.cancelCtx")).
+		Run(func(args mock.Arguments) { watched <- struct{}{} })
+	watcher.On("Events")
+	watcher.On("Close").Run(func(args mock.Arguments) { closed <- struct{}{} })
+	indexer := &MockIndexer{}
+	indexer.On("Create", path).Return(indexer)
+	indexer.On("CreateWatcher", path).Return(watcher)
+	indexer.On("Index", path, mock.AnythingOfType("watchers.Event"))
+
+	manager := NewManager(indexer, []struct{ Path string }{{Path: path}})
+	manager.Start(context.Background())
+	<-watched
+
+	assert.Nil(t, manager.Pause(path))
+	<-closed
+	assert.True(t, manager.Exists(path))
+
+	assert.Nil(t, manager.Resume(path))
+	<-watched
+	assert.Nil(t, manager.Shutdown(context.Background()))
+	<-closed
+}
+
+func Test_Manager_Pause_WillReturnError_WhenProjectDoesNotExist(t *testing.T) {
+	manager := NewManager(&MockIndexer{}, []struct{ Path string }{})
+	assert.Equal(t, ErrProjectNotFound, manager.Pause("/foo"))
+	assert.Equal(t, ErrProjectNotFound, manager.Resume("/foo"))
+}
+
+func Test_Manager_Paths_ReturnsSortedPaths(t *testing.T) {
+	manager := NewManager(&MockIndexer{}, []struct{ Path string }{})
+	manager.projects["/b"] = &ProjectWithContext{}
+	manager.projects["/a"] = &ProjectWithContext{}
+	assert.Equal(t, []string{"/a", "/b"}, manager.Paths())
+}
+
+func Test_Manager_Reload_WillAddAndRemoveProjects(t *testing.T) {
+	pathA, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(pathA)
+	pathB, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(pathB)
+
+	watcher := CreateMockWatcher()
+	indexer := &MockIndexer{}
+	for _, path := range []string{pathA, pathB} {
+		indexer.On("Create", path).Return(indexer)
+		indexer.On("CreateWatcher", path).Return(watcher)
+	}
+
+	manager := NewManager(indexer, []struct{ Path string }{{Path: pathA}})
+	manager.Reload(indexer, []struct{ Path string }{{Path: pathB}})
+	assert.Equal(t, []string{pathB}, manager.Paths())
+}
+
+func Test_Manager_Reload_WillRegisterMissingProjects(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	missing := filepath.Join(path, "missing")
+
+	indexer := &MockIndexer{}
+	indexer.On("Create", missing).Return(indexer)
+	indexer.On("CreateWatcher", missing).Return(CreateMockWatcher())
+	manager := NewManager(indexer, []struct{ Path string }{})
+	manager.Reload(indexer, []struct{ Path string }{{Path: missing}})
+	assert.Equal(t, []string{missing}, manager.Paths())
+	assert.True(t, manager.projects[missing].Missing)
+}
+
+func Test_Manager_Reload_WillRestartProjects_WhenIndexerChanges(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	projects := []struct{ Path string }{{Path: path}}
+	watcher := CreateMockWatcher()
+	indexer := &MockIndexer{}
+	indexer.On("Create", path).Return(indexer)
+	indexer.On("CreateWatcher", path).Return(watcher)
+	manager := NewManager(indexer, projects)
+	project := manager.projects[path].Project
+
+	// same settings: the project is left alone
+	manager.Reload(indexer, projects)
+	assert.Equal(t, project, manager.projects[path].Project)
+
+	other := &MockIndexer{}
+	other.On("Create", path).Return(other)
+	other.On("CreateWatcher", path).Return(watcher)
+	other.On("Index", path, mock.AnythingOfType("watchers.Event"))
+	manager.Reload(other, projects)
+	assert.NotEqual(t, project, manager.projects[path].Project)
+	assert.Equal(t, other, manager.projects[path].Project.(*Project).Indexer)
+}
+
+// RecordingIndexer records its runs; its first run blocks until aborted
+type RecordingIndexer struct {
+	Name    string
+	watcher watchers.Watchable
+	mu      *sync.Mutex
+	runs    *[]string
+}
+
+func (indexer *RecordingIndexer) Create(root string) indexers.Indexable { return indexer }
+
+func (indexer *RecordingIndexer) CreateWatcher(root string) watchers.Watchable {
+	return indexer.watcher
+}
+
+func (indexer *RecordingIndexer) Index(ctx context.Context, root string, event watchers.Event) error {
+	indexer.mu.Lock()
+	first := len(*indexer.runs) == 0
+	*indexer.runs = append(*indexer.runs, indexer.Name+" started")
+	indexer.mu.Unlock()
+	if first {
+		<-ctx.Done()
+	}
+	indexer.mu.Lock()
+	*indexer.runs = append(*indexer.runs, indexer.Name+" finished")
+	indexer.mu.Unlock()
+	return ctx.Err()
+}
+
+func Test_Manager_Reload_WillAbortAndWaitForTheOldRun(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	var mu sync.Mutex
+	runs := []string{}
+	recorded := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, runs...)
+	}
+	projects := []struct{ Path string }{{Path: path}}
+	old := &RecordingIndexer{Name: "old", watcher: CreateMockWatcher(), mu: &mu, runs: &runs}
+	manager := NewManager(old, projects)
+	manager.Start(context.Background())
+	defer manager.Shutdown(context.Background())
+	WaitFor(t, func() bool { return len(recorded()) == 1 })
+
+	manager.Reload(&RecordingIndexer{Name: "new", watcher: CreateMockWatcher(), mu: &mu, runs: &runs}, projects)
+	WaitFor(t, func() bool { return len(recorded()) == 4 })
+	assert.Equal(t, []string{"old started", "old finished", "new started", "new finished"}, recorded())
+}
+
+func Test_Manager_RecordsRuntimeChanges(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	watcher := CreateMockWatcher()
+	indexer := &MockIndexer{}
+	indexer.On("Create", path).Return(indexer)
+	indexer.On("CreateWatcher", path).Return(watcher)
+
+	manager := NewManager(indexer, []struct{ Path string }{})
+	manager.State, err = LoadState(filepath.Join(path, "state.yml"), "")
+	assert.Nil(t, err)
+
+	manager.Add(path)
+	assert.Equal(t, []string{path}, manager.State.Added)
+	assert.Nil(t, manager.Pause(path))
+	assert.True(t, manager.State.IsPaused(path))
+	manager.Remove(path)
+	assert.Empty(t, manager.State.Added)
+	assert.False(t, manager.State.IsPaused(path))
+}
+
+func Test_Manager_SetDiscovered(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	watcher := CreateMockWatcher()
+	indexer := &MockIndexer{}
+	indexer.On("Create", path).Return(indexer)
+	indexer.On("CreateWatcher", path).Return(watcher)
+	manager := NewManager(indexer, []struct{ Path string }{})
+
+	manager.SetDiscovered([]string{path})
+	assert.True(t, manager.projects[path].Discovered)
+	// a config reload does not remove discovered projects
+	manager.Reload(indexer, []struct{ Path string }{})
+	assert.True(t, manager.Exists(path))
+	manager.SetDiscovered([]string{})
+	assert.False(t, manager.Exists(path))
+}
+
+func Test_Manager_SetDiscovered_KeepsConfiguredProjects(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	watcher := CreateMockWatcher()
+	indexer := &MockIndexer{}
+	indexer.On("Create", path).Return(indexer)
+	indexer.On("CreateWatcher", path).Return(watcher)
+	manager := NewManager(indexer, []struct{ Path string }{{Path: path}})
+
+	manager.SetDiscovered([]string{path})
+	assert.False(t, manager.projects[path].Discovered)
+	manager.SetDiscovered([]string{})
+	assert.True(t, manager.Exists(path))
+}
+
+func Test_Manager_SetDiscovered_SkipsRemovedProjects(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	manager := NewManager(&MockIndexer{}, []struct{ Path string }{})
+	manager.State = &State{Removed: []string{path}}
+	manager.SetDiscovered([]string{path})
+	assert.False(t, manager.Exists(path))
+}
+
+func Test_Manager_CheckRoots_SuspendsAndResumesProjects(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	watched := make(chan struct{}, 2)
+	closed := make(chan struct{}, 2)
+	watcher := &MockWatcher{}
+	watcher.On("Watch", mock.AnythingOfType("*context.cancelCtx")).
+		Run(func(args mock.Arguments) { watched <- struct{}{} })
+	watcher.On("Events")
+	watcher.On("Close").Run(func(args mock.Arguments) { closed <- struct{}{} })
+	indexer := &MockIndexer{}
+	indexer.On("Create", path).Return(indexer)
+	indexer.On("CreateWatcher", path).Return(watcher)
+	indexer.On("Index", path, mock.AnythingOfType("watchers.Event"))
+
+	manager := NewManager(indexer, []struct{ Path string }{{Path: path}})
+	manager.Start(context.Background())
+	<-watched
+
+	assert.Nil(t, os.Remove(path))
+	manager.CheckRoots()
+	<-closed
+	assert.True(t, manager.projects[path].Missing)
+
+	Mkdir(t, path)
+	manager.CheckRoots()
+	<-watched
+	assert.False(t, manager.projects[path].Missing)
+	assert.Nil(t, manager.Shutdown(context.Background()))
+}
+
+func Test_Manager_SuspendsProject_WhenMonitorReturns(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	events := make(chan watchers.Event)
+	watcher := &MockWatcher{}
+	watcher.On("Watch", mock.AnythingOfType("*context.cancelCtx"))
+	watcher.On("Events").Return(events)
+	watcher.On("Close")
+	indexer := &MockIndexer{}
+	indexer.On("Create", path).Return(indexer)
+	indexer.On("CreateWatcher", path).Return(watcher)
+	indexer.On("Index", path, mock.AnythingOfType("watchers.Event"))
+
+	manager := NewManager(indexer, []struct{ Path string }{{Path: path}})
+	manager.Start(context.Background())
+	// the root disappears and the watcher reports it
+	assert.Nil(t, os.Remove(path))
+	events <- watchers.NewEvent()
+	WaitFor(t, func() bool {
+		manager.mu.RLock()
+		defer manager.mu.RUnlock()
+		return manager.projects[path].Missing
+	})
+}
+
+func CreateNestedProjects(t *testing.T) (string, string, *MockIndexer) {
+	parent, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	child := filepath.Join(parent, "child")
+	Mkdir(t, child)
+
+	watcher := CreateMockWatcher()
+	indexer := &MockIndexer{}
+	for _, path := range []string{parent, child} {
+		indexer.On("Create", path).Return(indexer)
+		indexer.On("CreateWatcher", path).Return(watcher)
+	}
+	return parent, child, indexer
+}
+
+func Test_Manager_Add_WillRejectNestedProjects_ByDefault(t *testing.T) {
+	parent, child, indexer := CreateNestedProjects(t)
+	defer os.RemoveAll(parent)
+
+	manager := NewManager(indexer, []struct{ Path string }{{Path: parent}})
+	manager.Add(child)
+	assert.Equal(t, []string{parent}, manager.Paths())
+
+	manager = NewManager(indexer, []struct{ Path string }{{Path: child}})
+	manager.Add(parent)
+	assert.Equal(t, []string{child}, manager.Paths())
+}
+
+func Test_Manager_Add_WillMergeNestedProjects(t *testing.T) {
+	parent, child, indexer := CreateNestedProjects(t)
+	defer os.RemoveAll(parent)
+
+	manager := NewManager(indexer, []struct{ Path string }{})
+	manager.Nesting = NestingMerge
+	manager.Add(parent)
+	manager.Add(child)
+	assert.Equal(t, []string{parent}, manager.Paths())
+
+	manager = NewManager(indexer, []struct{ Path string }{})
+	manager.Nesting = NestingMerge
+	manager.Add(child)
+	manager.Add(parent)
+	assert.Equal(t, []string{parent}, manager.Paths())
+}
+
+func Test_Manager_Add_WillExcludeSubprojects(t *testing.T) {
+	parent, child, _ := CreateNestedProjects(t)
+	defer os.RemoveAll(parent)
+
+	manager := NewManager(indexers.DefaultIndexer(), []struct{ Path string }{})
+	manager.Nesting = NestingSubproject
+	manager.Add(parent)
+	manager.Add(child)
+	assert.Equal(t, []string{parent, child}, manager.Paths())
+	indexer := manager.projects[parent].Project.(*Project).Indexer.(*indexers.Indexer)
+	assert.Equal(t, []string{child}, indexer.Subprojects)
+
+	manager.Remove(child)
+	indexer = manager.projects[parent].Project.(*Project).Indexer.(*indexers.Indexer)
+	assert.Empty(t, indexer.Subprojects)
+	manager.Remove(parent)
+}
+
+func Test_Manager_Remove_WillNotRestartParent_AfterShutdown(t *testing.T) {
+	parent, child, _ := CreateNestedProjects(t)
+	defer os.RemoveAll(parent)
+
+	manager := NewManager(indexers.DefaultIndexer(), []struct{ Path string }{})
+	manager.Nesting = NestingSubproject
+	manager.Add(parent)
+	manager.Add(child)
+	manager.Start(context.Background())
+	assert.Nil(t, manager.Shutdown(context.Background()))
+
+	assert.Nil(t, manager.Remove(child))
+	assert.Nil(t, manager.projects[parent].Cancel)
+	assert.Nil(t, manager.Shutdown(context.Background()))
+}
+
+func Test_Manager_Resolve_ReturnsInnermostProject(t *testing.T) {
+	parent, child, _ := CreateNestedProjects(t)
+	defer os.RemoveAll(parent)
+
+	manager := NewManager(indexers.DefaultIndexer(), []struct{ Path string }{})
+	manager.Nesting = NestingSubproject
+	manager.Add(parent)
+	manager.Add(child)
+	defer manager.Remove(child)
+	defer manager.Remove(parent)
+
+	status, err := manager.Resolve(filepath.Join(child, "lib", "foo.go"))
+	assert.Nil(t, err)
+	assert.Equal(t, child, status.Path)
+	assert.Equal(t, filepath.Join(child, "TAGS"), status.TagFiles[0].Path)
+	status, err = manager.Resolve(filepath.Join(parent, "foo.go"))
+	assert.Nil(t, err)
+	assert.Equal(t, parent, status.Path)
+	status, err = manager.Resolve(parent)
+	assert.Nil(t, err)
+	assert.Equal(t, parent, status.Path)
+	_, err = manager.Resolve(parent + "foo/bar.go")
+	assert.Equal(t, ErrProjectNotFound, err)
+}
+
+func Test_Manager_subprojectsOf_ReturnsOutermostNestedProjects(t *testing.T) {
+	manager := NewManager(&MockIndexer{}, []struct{ Path string }{})
+	for _, path := range []string{"/a", "/a/b", "/a/b/c", "/a/d", "/ab"} {
+		manager.projects[path] = &ProjectWithContext{}
+	}
+	assert.Equal(t, []string{"/a/b", "/a/d"}, manager.subprojectsOf("/a"))
+	assert.Equal(t, "/a/b", manager.parentOf("/a/b/c"))
+	assert.Equal(t, "", manager.parentOf("/ab"))
+}
+
+func Test_isNested(t *testing.T) {
+	assert.True(t, isNested("/a/b", "/a"))
+	assert.True(t, isNested("/a/b/c", "/a"))
+	assert.False(t, isNested("/a", "/a"))
+	assert.False(t, isNested("/ab", "/a"))
+	assert.False(t, isNested("/a", "/a/b"))
+}
+
+func Test_Manager_Find_ByIdOrPath(t *testing.T) {
+	manager := NewManager(&MockIndexer{}, []struct{ Path string }{})
+	manager.projects["/a"] = &ProjectWithContext{Paused: true, status: NewStatus()}
+
+	info, err := manager.Find("/a")
+	assert.Nil(t, err)
+	assert.Equal(t, ProjectInfo{ID: ProjectID("/a"), Path: "/a", Paused: true, State: StatePaused}, info)
+	info, err = manager.Find(ProjectID("/a"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/a", info.Path)
+	_, err = manager.Find("/b")
+	assert.Equal(t, ErrProjectNotFound, err)
+}
+
+func Test_Manager_Status_ReportsHistoryAndTagFiles(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	tags := "\x0c\nfoo.go,20\nfunc Foo(\x7fFoo\x011,0\nfunc Bar(\x7fBar\x012,10\n"
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(path, "TAGS"), []byte(tags), 0644))
+	manager := NewManager(indexers.DefaultIndexer(), []struct{ Path string }{{Path: path}})
+	defer manager.Remove(path)
+
+	project := manager.projects[path].Project.(*Project)
+	project.Status.End(project.Status.Begin(), &utils.ExecError{ExitCode: 2, Err: errors.New("exit status 2"), Output: []byte("oops")})
+
+	status, err := manager.Status(ProjectID(path))
+	assert.Nil(t, err)
+	assert.Equal(t, StateFailed, status.State)
+	assert.Equal(t, 2, status.LastRun.ExitCode)
+	assert.Equal(t, "oops", status.LastRun.Output)
+	assert.Len(t, status.Runs, 1)
+	assert.Equal(t, []TagFileInfo{{
+		Path:     filepath.Join(path, "TAGS"),
+		Exists:   true,
+		Size:     int64(len(tags)),
+		Modified: status.TagFiles[0].Modified,
+		Entries:  2,
+	}}, status.TagFiles)
+
+	// the history survives restarts
+	manager.restart(path)
+	info, _ := manager.Find(path)
+	assert.Equal(t, StateFailed, info.State)
+}
+
+func Test_Manager_Reindex_QueuesIndexingRun(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	indexed := make(chan watchers.Event, 2)
+	indexer := &MockIndexer{}
+	indexer.On("Create", path).Return(indexer)
+	indexer.On("CreateWatcher", path).Return(CreateMockWatcher())
+	indexer.On("Index", path, mock.AnythingOfType("watchers.Event")).
+		Run(func(args mock.Arguments) { indexed <- args.Get(1).(watchers.Event) })
+
+	manager := NewManager(indexer, []struct{ Path string }{{Path: path}})
+	assert.Equal(t, ErrProjectInactive, manager.Reindex(path, ReindexOptions{}))
+	assert.Empty(t, manager.ReindexAll(ReindexOptions{}))
+	assert.Equal(t, ErrProjectNotFound, manager.Reindex("foo", ReindexOptions{}))
+
+	manager.Start(context.Background())
+	defer manager.Shutdown(context.Background())
+	<-indexed
+	assert.Nil(t, manager.Reindex(ProjectID(path), ReindexOptions{Dependencies: true}))
+	assert.True(t, (<-indexed).Dependencies)
+
+	projects := manager.ReindexAll(ReindexOptions{Full: true})
+	assert.Len(t, projects, 1)
+	assert.True(t, (<-indexed).Full)
+}
@@ -0,0 +1,18 @@
+package main
+
+import "github.com/kkentzo/tagger/metrics"
+
+var (
+	indexingDuration = metrics.NewHistogram("tagger_indexing_duration_seconds",
+		"Duration of indexing runs", metrics.DefaultBuckets, "project")
+	indexingFailures = metrics.NewCounter("tagger_indexing_failures_total",
+		"Failed indexing runs", "project")
+	queueDepth = metrics.NewGauge("tagger_indexing_queue_depth",
+		"Indexing runs waiting for the running one to finish", "project")
+	tagFileSize = metrics.NewGauge("tagger_tag_file_size_bytes",
+		"Size of tag files", "project", "file")
+	symbols = metrics.NewGauge("tagger_symbols",
+		"Symbols held in memory", "project")
+	symbolMemory = metrics.NewGauge("tagger_symbols_memory_bytes",
+		"Approximate memory used by the symbols", "project")
+)
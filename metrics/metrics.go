@@ -0,0 +1,264 @@
+package metrics
+
+// a minimal implementation of metrics exposed in the prometheus text
+// format (https://prometheus.io/docs/instrumenting/exposition_formats/)
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the default buckets of histograms (in seconds)
+var DefaultBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120}
+
+type metric interface {
+	write(io.Writer)
+	deleteLabel(name string, value string)
+}
+
+// Registry is a collection of metrics that are exposed together
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// Default is the registry of the metrics created by the New* functions
+var Default = &Registry{}
+
+func (registry *Registry) register(m metric) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.metrics = append(registry.metrics, m)
+}
+
+// Write writes all metrics in the text exposition format
+func (registry *Registry) Write(w io.Writer) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for _, m := range registry.metrics {
+		m.write(w)
+	}
+}
+
+// DeleteLabel removes the series of all metrics whose label name has the
+// given value (e.g. the series of a removed project)
+func (registry *Registry) DeleteLabel(name string, value string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for _, m := range registry.metrics {
+		m.deleteLabel(name, value)
+	}
+}
+
+// Handler serves the metrics of the registry
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		registry.Write(w)
+	})
+}
+
+// vec holds the series of a metric keyed by their label values
+type vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// histograms only
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newVec(name string, help string, kind string, labels []string) *vec {
+	return &vec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+}
+
+// get returns the series of the label values; must be called with the
+// lock held
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values", v.name, len(v.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec) deleteLabel(name string, value string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for i, label := range v.labels {
+		if label != name {
+			continue
+		}
+		for key, s := range v.series {
+			if s.labelValues[i] == value {
+				delete(v.series, key)
+			}
+		}
+	}
+}
+
+// sorted returns the series ordered by their label values; must be
+// called with the lock held
+func (v *vec) sorted() []*series {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]*series, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, v.series[key])
+	}
+	return result
+}
+
+func (v *vec) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+}
+
+func (v *vec) labelString(s *series, extra ...string) string {
+	pairs := []string{}
+	for i, label := range v.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, escaper.Replace(s.labelValues[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escaper.Replace(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.header(w)
+	for _, s := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelString(s), formatFloat(s.value))
+	}
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing value per label combination
+type Counter struct {
+	*vec
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	counter := &Counter{newVec(name, help, "counter", labels)}
+	Default.register(counter)
+	return counter
+}
+
+func (counter *Counter) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+func (counter *Counter) Add(value float64, labelValues ...string) {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	counter.get(labelValues).value += value
+}
+
+// Gauge is a value that can go up and down per label combination
+type Gauge struct {
+	*vec
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	gauge := &Gauge{newVec(name, help, "gauge", labels)}
+	Default.register(gauge)
+	return gauge
+}
+
+func (gauge *Gauge) Set(value float64, labelValues ...string) {
+	gauge.mu.Lock()
+	defer gauge.mu.Unlock()
+	gauge.get(labelValues).value = value
+}
+
+func (gauge *Gauge) Add(value float64, labelValues ...string) {
+	gauge.mu.Lock()
+	defer gauge.mu.Unlock()
+	gauge.get(labelValues).value += value
+}
+
+// Histogram counts observations in cumulative buckets per label
+// combination
+type Histogram struct {
+	*vec
+	buckets []float64
+}
+
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	histogram := &Histogram{newVec(name, help, "histogram", labels), buckets}
+	Default.register(histogram)
+	return histogram
+}
+
+func (histogram *Histogram) Observe(value float64, labelValues ...string) {
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+	s := histogram.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(histogram.buckets))
+	}
+	for i, bound := range histogram.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (histogram *Histogram) write(w io.Writer) {
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+	histogram.header(w)
+	for _, s := range histogram.sorted() {
+		for i, bound := range histogram.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", histogram.name,
+				histogram.labelString(s, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", histogram.name,
+			histogram.labelString(s, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", histogram.name, histogram.labelString(s), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", histogram.name, histogram.labelString(s), s.count)
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Registry_Write_UsesTextFormat(t *testing.T) {
+	registry := &Registry{}
+	counter := &Counter{newVec("foo_total", "Foos", "counter", []string{"project"})}
+	gauge := &Gauge{newVec("bar", "Bars", "gauge", nil)}
+	histogram := &Histogram{newVec("baz_seconds", "Bazs", "histogram", []string{"project"}), []float64{1, 2}}
+	registry.register(counter)
+	registry.register(gauge)
+	registry.register(histogram)
+
+	counter.Inc("/b")
+	counter.Add(2, `/a"`)
+	gauge.Set(3)
+	gauge.Add(-1)
+	histogram.Observe(1.5, "/a")
+	histogram.Observe(3, "/a")
+
+	w := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, `# HELP foo_total Foos
+# TYPE foo_total counter
+foo_total{project="/a\""} 2
+foo_total{project="/b"} 1
+# HELP bar Bars
+# TYPE bar gauge
+bar 2
+# HELP baz_seconds Bazs
+# TYPE baz_seconds histogram
+baz_seconds_bucket{project="/a",le="1"} 0
+baz_seconds_bucket{project="/a",le="2"} 1
+baz_seconds_bucket{project="/a",le="+Inf"} 2
+baz_seconds_sum{project="/a"} 4.5
+baz_seconds_count{project="/a"} 2
+`, w.Body.String())
+	assert.Equal(t, "text/plain; version=0.0.4", w.Header().Get("Content-Type"))
+}
+
+func Test_Registry_DeleteLabel_RemovesSeries(t *testing.T) {
+	registry := &Registry{}
+	counter := &Counter{newVec("foo_total", "Foos", "counter", []string{"project"})}
+	registry.register(counter)
+	counter.Inc("/a")
+	counter.Inc("/b")
+
+	registry.DeleteLabel("project", "/a")
+	var buf bytes.Buffer
+	registry.Write(&buf)
+	assert.NotContains(t, buf.String(), "/a")
+	assert.Contains(t, buf.String(), `foo_total{project="/b"} 1`)
+}
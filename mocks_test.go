@@ -17,8 +17,12 @@ func (indexer *MockIndexer) Create(root string) indexers.Indexable {
 	return args.Get(0).(indexers.Indexable)
 }
 
-func (indexer *MockIndexer) Index(root string, event watchers.Event) {
-	indexer.Called(root, event)
+func (indexer *MockIndexer) Index(ctx context.Context, root string, event watchers.Event) error {
+	args := indexer.Called(root, event)
+	if len(args) > 0 {
+		return args.Error(0)
+	}
+	return nil
 }
 
 func (indexer *MockIndexer) CreateWatcher(root string) watchers.Watchable {
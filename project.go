@@ -2,8 +2,16 @@ package main
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/kkentzo/tagger/indexers"
+	"github.com/kkentzo/tagger/tags"
+	"github.com/kkentzo/tagger/utils"
 	"github.com/kkentzo/tagger/watchers"
 	log "github.com/sirupsen/logrus"
 )
@@ -12,12 +20,37 @@ type Monitorable interface {
 	Monitor(context.Context)
 	// TODO: change arg to pointer
 	Index(watchers.Event)
+	// Reindex queues an indexing run while the project is monitored
+	Reindex(watchers.Event)
 }
 
 type Project struct {
 	Path    string
 	Indexer indexers.Indexable
 	Watcher watchers.Watchable
+	// cancelling Abort kills any running indexing process
+	Abort context.Context
+	// records the outcome of indexing runs
+	Status *Status
+	// receives the project's change and indexing events (optional)
+	Events *Broker
+	// holds the project's symbols (optional)
+	Tags *tags.DB
+	// the modification times of the secondary tag files at the last
+	// load of the symbols
+	dependencies map[string]time.Time
+
+	mu sync.Mutex
+	// the reindex requests that have not been picked up by Monitor yet
+	requested *watchers.Event
+	requests  chan struct{}
+}
+
+// ProjectID returns a stable, url-friendly identifier for the project
+// at path
+func ProjectID(path string) string {
+	sum := sha1.Sum([]byte(path))
+	return hex.EncodeToString(sum[:])[:12]
 }
 
 func DefaultProject(indexer indexers.Indexable, watcher watchers.Watchable) *Project {
@@ -25,28 +58,212 @@ func DefaultProject(indexer indexers.Indexable, watcher watchers.Watchable) *Pro
 		Path:    ".",
 		Indexer: indexer,
 		Watcher: watcher,
+		Abort:   context.Background(),
+		Status:  NewStatus(),
 	}
 }
 
+// Monitor watches the project and indexes it on every watcher event or
+// reindex request until ctx is cancelled or the project root disappears.
+// Indexing runs are performed one at a time; the events that arrive
+// during a run are merged into the next one. The in-flight run is waited
+// for (or aborted) before returning.
 func (project *Project) Monitor(ctx context.Context) {
+	var indexing sync.WaitGroup
+	done := make(chan struct{}, 1)
+	running := false
+	var pending *watchers.Event
+	index := func(event watchers.Event) {
+		if running {
+			if pending != nil {
+				event = pending.Merge(event)
+			}
+			pending = &event
+			queueDepth.Set(1, project.Path)
+			return
+		}
+		running = true
+		indexing.Add(1)
+		go func() {
+			defer indexing.Done()
+			project.Index(event)
+			done <- struct{}{}
+		}()
+	}
+	// the tags of a previous session are available until the initial
+	// indexing completes
+	if path := project.tagFile(); path != "" && utils.FileExists(path) {
+		project.loadTags(watchers.NewEvent(), nil)
+	}
 	// perform an initial indexing
-	go project.Index(watchers.NewEvent())
-	defer project.Watcher.Close()
+	index(watchers.NewEvent())
 	wctx, cancel := context.WithCancel(ctx)
-	go project.Watcher.Watch(wctx)
+	watching := make(chan struct{})
+	go func() {
+		project.Watcher.Watch(wctx)
+		close(watching)
+	}()
+	stop := func() {
+		cancel()
+		// the watcher must not be closed while still sending events
+		<-watching
+		project.Watcher.Close()
+		indexing.Wait()
+	}
 	for {
 		select {
 		case e := <-project.Watcher.Events():
-			// TODO: is this indexing goroutine thread-safe here?
-			go project.Index(e)
+			if !utils.FileExists(project.Path) {
+				log.Warnf("Project root %s has disappeared", project.Path)
+				stop()
+				return
+			}
+			changes := NewProjectEvent(EventChangesDetected, project.Path)
+			if e.Names != nil {
+				changes.Files = e.Names.Elements()
+			}
+			project.Events.Publish(changes)
+			index(e)
+		case <-project.notifications():
+			project.mu.Lock()
+			e := project.requested
+			project.requested = nil
+			project.mu.Unlock()
+			if e != nil {
+				index(*e)
+			}
+		case <-done:
+			running = false
+			if pending != nil {
+				e := *pending
+				pending = nil
+				queueDepth.Set(0, project.Path)
+				index(e)
+			}
 		case <-ctx.Done():
-			cancel()
+			stop()
 			return
 		}
 	}
 }
 
+// Reindex merges event into the pending requests of the project and
+// notifies Monitor; it never blocks
+func (project *Project) Reindex(event watchers.Event) {
+	project.mu.Lock()
+	if project.requested != nil {
+		event = project.requested.Merge(event)
+	}
+	project.requested = &event
+	project.mu.Unlock()
+	select {
+	case project.notifications() <- struct{}{}:
+	default:
+	}
+}
+
+func (project *Project) notifications() chan struct{} {
+	project.mu.Lock()
+	defer project.mu.Unlock()
+	if project.requests == nil {
+		project.requests = make(chan struct{}, 1)
+	}
+	return project.requests
+}
+
+// tagFiles returns the tag files of the project; the primary one (which
+// includes the tags of the dependencies) comes first
+func (project *Project) tagFiles() []string {
+	if tf, ok := project.Indexer.(indexers.TagFileable); ok {
+		return tf.TagFiles(project.Path)
+	}
+	return []string{}
+}
+
+func (project *Project) tagFile() string {
+	if paths := project.tagFiles(); len(paths) > 0 {
+		return paths[0]
+	}
+	return ""
+}
+
+// loadTags refreshes the project's symbols in Tags after an indexing run
+// triggered by event; only the tags of the changed files are reloaded
+// unless the whole project (or its dependencies) has been reindexed. The
+// tags that the indexer returned (if any) are used instead of the tag
+// file.
+func (project *Project) loadTags(event watchers.Event, found []tags.Tag) {
+	paths := project.tagFiles()
+	if project.Tags == nil || len(paths) == 0 {
+		return
+	}
+	if found != nil {
+		project.Tags.Set(project.Path, found)
+		project.updateSymbolMetrics()
+		return
+	}
+	dependencies := make(map[string]time.Time)
+	for _, path := range paths[1:] {
+		if info, err := os.Stat(path); err == nil {
+			dependencies[path] = info.ModTime()
+		}
+	}
+	full := event.Full || event.Dependencies || event.Names == nil ||
+		event.Names.Len() == 0 || !reflect.DeepEqual(dependencies, project.dependencies)
+	var err error
+	if full {
+		err = project.Tags.Load(project.Path, paths[0])
+	} else {
+		err = project.Tags.Update(project.Path, paths[0], event.Names.Elements())
+	}
+	if err != nil {
+		log.Errorf("Unable to load the tags of %s: %s", project.Path, err)
+		return
+	}
+	project.dependencies = dependencies
+	project.updateSymbolMetrics()
+}
+
+func (project *Project) updateSymbolMetrics() {
+	if stats, ok := project.Tags.Stats(project.Path); ok {
+		symbols.Set(float64(stats.Tags), project.Path)
+		symbolMemory.Set(float64(stats.Memory), project.Path)
+	}
+}
+
 func (project *Project) Index(event watchers.Event) {
+	ctx := project.Abort
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	log.Info("Indexing ", project.Path)
-	project.Indexer.Index(project.Path, event)
+	start := project.Status.Begin()
+	project.Events.Publish(NewProjectEvent(EventIndexingStarted, project.Path))
+	var found []tags.Tag
+	var err error
+	if structured, ok := project.Indexer.(indexers.Structured); ok {
+		found, err = structured.IndexTags(ctx, project.Path, event)
+	} else {
+		err = project.Indexer.Index(ctx, project.Path, event)
+	}
+	run := project.Status.End(start, err)
+	indexingDuration.Observe(run.End.Sub(run.Start).Seconds(), project.Path)
+	finished := NewProjectEvent(EventIndexingFinished, project.Path)
+	if err != nil {
+		log.Errorf("Indexing %s failed: %s", project.Path, err)
+		finished.Type = EventIndexingFailed
+		indexingFailures.Inc(project.Path)
+	}
+	if tf, ok := project.Indexer.(indexers.TagFileable); ok {
+		for _, path := range tf.TagFiles(project.Path) {
+			if info, err := os.Stat(path); err == nil {
+				tagFileSize.Set(float64(info.Size()), project.Path, path)
+			}
+		}
+	}
+	if err == nil {
+		project.loadTags(event, found)
+	}
+	finished.Run = &run
+	project.Events.Publish(finished)
 }
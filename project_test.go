@@ -2,8 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/kkentzo/tagger/indexers"
+	"github.com/kkentzo/tagger/tags"
 	"github.com/kkentzo/tagger/watchers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -69,3 +76,169 @@ func Test_Project_Index_WillCallTheIndexer(t *testing.T) {
 	project.Index(watchers.Event{})
 	assert.True(t, called)
 }
+
+func Test_Project_Monitor_WillWaitForIndexing_OnContextCancellation(t *testing.T) {
+	indexer := &MockIndexer{}
+	watcher := CreateMockWatcher()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	indexer.On("Index", ".", mock.AnythingOfType("watchers.Event")).
+		Run(func(args mock.Arguments) {
+			close(started)
+			<-release
+		})
+
+	project := DefaultProject(indexer, watcher)
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		project.Monitor(ctx)
+		close(returned)
+	}()
+	<-started
+	cancel()
+	select {
+	case <-returned:
+		t.Fatal("Monitor returned while indexing was in progress")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	<-returned
+}
+
+func Test_Project_Monitor_WillReturn_WhenRootDisappears(t *testing.T) {
+	indexer := &MockIndexer{}
+	watcher := &MockWatcher{}
+	events := make(chan watchers.Event)
+	watcher.On("Events").Return(events)
+	watcher.On("Watch", mock.AnythingOfType("*context.cancelCtx"))
+	watcher.On("Close")
+	indexer.On("Index", "/foo/bar", mock.AnythingOfType("watchers.Event"))
+
+	project := DefaultProject(indexer, watcher)
+	project.Path = "/foo/bar"
+	returned := make(chan struct{})
+	go func() {
+		project.Monitor(context.Background())
+		close(returned)
+	}()
+	events <- watchers.NewEvent()
+	<-returned
+	watcher.AssertCalled(t, "Close")
+}
+
+func Test_Project_Monitor_WillIndexProject_OnReindexRequest(t *testing.T) {
+	indexer := &MockIndexer{}
+	watcher := CreateMockWatcher()
+
+	indexed := make(chan watchers.Event, 2)
+	indexer.On("Index", ".", mock.AnythingOfType("watchers.Event")).
+		Run(func(args mock.Arguments) { indexed <- args.Get(1).(watchers.Event) })
+
+	project := DefaultProject(indexer, watcher)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go project.Monitor(ctx)
+	<-indexed
+
+	event := watchers.NewEvent()
+	event.Full = true
+	project.Reindex(event)
+	assert.True(t, (<-indexed).Full)
+}
+
+func Test_Project_Monitor_WillMergeRequests_WhileIndexing(t *testing.T) {
+	indexer := &MockIndexer{}
+	watcher := CreateMockWatcher()
+
+	indexed := make(chan watchers.Event, 3)
+	release := make(chan struct{})
+	indexer.On("Index", ".", mock.AnythingOfType("watchers.Event")).
+		Run(func(args mock.Arguments) {
+			indexed <- args.Get(1).(watchers.Event)
+			<-release
+		})
+
+	project := DefaultProject(indexer, watcher)
+	ctx, cancel := context.WithCancel(context.Background())
+	go project.Monitor(ctx)
+	<-indexed
+
+	full := watchers.NewEvent()
+	full.Full = true
+	dependencies := watchers.NewEvent()
+	dependencies.Dependencies = true
+	project.Reindex(full)
+	project.Reindex(dependencies)
+	release <- struct{}{}
+
+	event := <-indexed
+	assert.True(t, event.Full)
+	assert.True(t, event.Dependencies)
+	release <- struct{}{}
+	select {
+	case <-indexed:
+		t.Fatal("requests were not merged into a single run")
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancel()
+}
+
+func Test_Project_Index_WillPublishIndexingEvents(t *testing.T) {
+	indexer := &MockIndexer{}
+	indexer.On("Index", ".", mock.AnythingOfType("watchers.Event")).Return(errors.New("foo"))
+
+	project := DefaultProject(indexer, &MockWatcher{})
+	project.Events = NewBroker()
+	subscription := project.Events.Subscribe(nil)
+	project.Index(watchers.NewEvent())
+
+	assert.Equal(t, EventIndexingStarted, (<-subscription.Events()).Type)
+	event := <-subscription.Events()
+	assert.Equal(t, EventIndexingFailed, event.Type)
+	assert.Equal(t, "foo", event.Run.Error)
+}
+
+func Test_Project_Index_WillLoadTags(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	// the indexer does not touch the existing tag file
+	tagFile := filepath.Join(path, "tags")
+	assert.Nil(t, ioutil.WriteFile(tagFile, []byte("Foo\tfoo.go\t3;\"\tf\n"), 0644))
+
+	project := DefaultProject(&indexers.Indexer{Program: "true", TagFileName: "tags"}, &MockWatcher{})
+	project.Path = path
+	project.Tags = tags.NewDB()
+	project.Index(watchers.NewEvent())
+
+	results := project.Tags.Search(tags.Query{Name: "Foo"})
+	assert.Len(t, results, 1)
+	assert.Equal(t, filepath.Join(path, "foo.go"), results[0].File)
+}
+
+// StructuredIndexer returns tags without writing a tag file
+type StructuredIndexer struct {
+	*indexers.Indexer
+	Tags []tags.Tag
+}
+
+func (indexer *StructuredIndexer) IndexTags(context.Context, string, watchers.Event) ([]tags.Tag, error) {
+	return indexer.Tags, nil
+}
+
+func Test_Project_Index_WillLoadStructuredTags(t *testing.T) {
+	indexer := &StructuredIndexer{
+		Indexer: &indexers.Indexer{Program: "true", TagFileName: "TAGS"},
+		Tags:    []tags.Tag{{Name: "Foo", File: "foo.go", Line: 3}},
+	}
+	project := DefaultProject(indexer, &MockWatcher{})
+	project.Path = "/foo"
+	project.Tags = tags.NewDB()
+	project.Index(watchers.NewEvent())
+
+	results := project.Tags.Search(tags.Query{Name: "Foo"})
+	assert.Len(t, results, 1)
+	assert.Equal(t, "/foo/foo.go", results[0].File)
+}
@@ -1,34 +1,543 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kkentzo/tagger/metrics"
+	"github.com/kkentzo/tagger/tags"
+	"github.com/kkentzo/tagger/utils"
 
 	log "github.com/sirupsen/logrus"
 )
 
+const ApiPrefix = "/api/v1"
+
+// how often a comment is sent to idle event streams so that dead clients
+// are detected
+var EventsKeepAlivePeriod = 30 * time.Second
+
 type Server struct {
 	Manager *Manager
-	Port    int
+	// the path of the unix socket (if any)
+	Socket string
+	// the tcp address (if any)
+	Address string
+	// if set, all requests must carry it as a bearer token
+	Token string
+	// whether the pprof endpoints are served
+	Profiling bool
+	http      *http.Server
+	// closed on shutdown in order to end the event streams
+	closing chan struct{}
+}
+
+func NewServer(manager *Manager, listen Listen, token string) *Server {
+	server := &Server{
+		Manager: manager,
+		Socket:  listen.Socket,
+		Address: listen.TCP,
+		Token:   token,
+		closing: make(chan struct{}),
+	}
+	server.http = &http.Server{}
+	server.http.RegisterOnShutdown(func() { close(server.closing) })
+	return server
+}
+
+// DefaultSocketPath returns $XDG_RUNTIME_DIR/tagger.sock or, if the
+// runtime directory is not set, a socket in a private per-user directory
+// under the temp directory
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "tagger.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("tagger-%d", os.Getuid()), "tagger.sock")
 }
 
-func (server *Server) Listen() {
+// ownedByUser returns true if info belongs to the current user
+func ownedByUser(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(stat.Uid) == os.Getuid()
+}
+
+// checkSocketDir makes sure that other users can not replace the socket
+// in dir: it must belong to the current user (or root) and must not be
+// writable by others
+func checkSocketDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || (int(stat.Uid) != os.Getuid() && stat.Uid != 0) || info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s must be a directory that is owned by the current user and not writable by others", dir)
+	}
+	return nil
+}
+
+// Handler returns the handler that serves all of the server's endpoints
+func (server *Server) Handler() http.Handler {
 	// register handlers
-	http.HandleFunc("/projects", func(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/projects", func(w http.ResponseWriter, r *http.Request) {
 		httpHandler(w, r, server.Manager)
 	})
-	// launch server
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", server.Port), nil))
+	mux.HandleFunc(ApiPrefix+"/projects", server.projectsHandler)
+	mux.HandleFunc(ApiPrefix+"/projects/", server.projectHandler)
+	mux.HandleFunc(ApiPrefix+"/reindex", server.reindexAllHandler)
+	mux.HandleFunc(ApiPrefix+"/events", server.eventsHandler)
+	mux.HandleFunc(ApiPrefix+"/symbols", server.symbolsHandler)
+	mux.HandleFunc(ApiPrefix+"/resolve", server.resolveHandler)
+	mux.Handle("/metrics", metrics.Default.Handler())
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/readyz", server.readyHandler)
+	if server.Profiling {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	if server.Token != "" {
+		return requireToken(server.Token, mux)
+	}
+	return mux
+}
+
+// Listen blocks serving requests on the unix socket and the tcp address
+// until the server fails or is shut down; in the latter case the returned
+// error is nil
+func (server *Server) Listen() error {
+	server.http.Handler = server.Handler()
+	listeners := []net.Listener{}
+	if server.Socket != "" {
+		listener, err := listenUnix(server.Socket)
+		if err != nil {
+			return err
+		}
+		log.Info("Listening on ", server.Socket)
+		listeners = append(listeners, listener)
+	}
+	if server.Address != "" {
+		listener, err := net.Listen("tcp", server.Address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		log.Info("Listening on ", server.Address)
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return errors.New("no listeners have been configured")
+	}
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			errs <- server.http.Serve(listener)
+		}(listener)
+	}
+	err := <-errs
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
 }
 
+// listenUnix listens on a socket that is accessible only by the current
+// user; the stale socket of a previous instance is replaced but any other
+// file at path is left alone
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := checkSocketDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Shutdown stops accepting connections and waits for active requests
+func (server *Server) Shutdown(ctx context.Context) error {
+	return server.http.Shutdown(ctx)
+}
+
+// GET /healthz
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
+
+// GET /readyz
+// ready once all projects have completed their initial indexing
+func (server *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	pending := server.Manager.Pending()
+	status := http.StatusOK
+	if len(pending) > 0 || !server.Manager.Started() {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, struct {
+		Ready   bool     `json:"ready"`
+		Pending []string `json:"pending"`
+	}{Ready: status == http.StatusOK, Pending: pending})
+}
+
+// GET, POST /api/v1/projects
+func (server *Server) projectsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, server.Manager.Projects())
+	case "POST":
+		var project struct{ Path string }
+		if err := json.NewDecoder(r.Body).Decode(&project); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if project.Path == "" {
+			writeError(w, http.StatusUnprocessableEntity, "path is required")
+			return
+		}
+		// the daemon's working directory is meaningless to clients
+		path := projectPath(project.Path)
+		if !filepath.IsAbs(path) {
+			writeError(w, http.StatusUnprocessableEntity, ErrRelativePath.Error())
+			return
+		}
+		log.Debug("Received POST for ", path)
+		if err := server.Manager.Add(path); err != nil {
+			writeError(w, statusOf(err), err.Error())
+			return
+		}
+		info, err := server.Manager.Find(path)
+		if err != nil {
+			// removed in the meantime
+			writeError(w, statusOf(err), err.Error())
+			return
+		}
+		w.Header().Set("Location", ApiPrefix+"/projects/"+info.ID)
+		writeJSON(w, http.StatusCreated, info)
+	default:
+		methodNotAllowed(w, "GET", "POST")
+	}
+}
+
+// GET, DELETE /api/v1/projects/{id}
+// GET /api/v1/projects/{id}/status
+// POST /api/v1/projects/{id}/reindex
+func (server *Server) projectHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, ApiPrefix+"/projects/"), "/")
+	id := parts[0]
+	if id == "" || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, "no such resource")
+		return
+	}
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "status":
+			server.statusHandler(w, r, id)
+		case "reindex":
+			server.reindexHandler(w, r, id)
+		default:
+			writeError(w, http.StatusNotFound, "no such resource")
+		}
+		return
+	}
+	info, err := server.Manager.Find(id)
+	if err != nil {
+		writeError(w, statusOf(err), err.Error())
+		return
+	}
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, info)
+	case "DELETE":
+		log.Debug("Received DELETE for ", info.Path)
+		if err := server.Manager.Remove(info.Path); err != nil {
+			writeError(w, statusOf(err), err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "GET", "DELETE")
+	}
+}
+
+func (server *Server) statusHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	status, err := server.Manager.Status(id)
+	if err != nil {
+		writeError(w, statusOf(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (server *Server) reindexHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	options, ok := decodeReindexOptions(w, r)
+	if !ok {
+		return
+	}
+	if err := server.Manager.Reindex(id, options); err != nil {
+		writeError(w, statusOf(err), err.Error())
+		return
+	}
+	info, err := server.Manager.Find(id)
+	if err != nil {
+		writeError(w, statusOf(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, info)
+}
+
+// POST /api/v1/reindex
+func (server *Server) reindexAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	options, ok := decodeReindexOptions(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusAccepted, server.Manager.ReindexAll(options))
+}
+
+// GET /api/v1/events[?project={id or path}...]
+// streams project events as server-sent events until the client
+// disconnects, falls too far behind or the server shuts down
+func (server *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	subscription := server.Manager.Events.Subscribe(r.URL.Query()["project"])
+	defer server.Manager.Events.Unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(EventsKeepAlivePeriod)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-server.closing:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event, ok := <-subscription.Events():
+			if !ok {
+				log.Debug("Dropping slow event subscriber ", r.RemoteAddr)
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Error("Unable to encode event: ", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// the default maximum number of symbols returned by a lookup
+var DefaultSymbolLimit = 100
+
+// Symbol is a symbol lookup result
+type Symbol struct {
+	tags.Tag
+	// the id and path of the project the symbol belongs to
+	Project string `json:"project"`
+	Path    string `json:"path"`
+	// fuzzy lookups only
+	Score int `json:"score,omitempty"`
+}
+
+// GET /api/v1/symbols?name=...[&project=...][&kind=...][&limit=n][&prefix=true]
+// [&ignore_case=true][&fuzzy=true]
+func (server *Server) symbolsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	params := r.URL.Query()
+	query := tags.Query{
+		Name:  params.Get("name"),
+		Kind:  params.Get("kind"),
+		Limit: DefaultSymbolLimit,
+	}
+	if query.Name == "" {
+		writeError(w, http.StatusUnprocessableEntity, "name is required")
+		return
+	}
+	var err error
+	for name, value := range map[string]*bool{
+		"prefix":      &query.Prefix,
+		"ignore_case": &query.IgnoreCase,
+		"fuzzy":       &query.Fuzzy,
+	} {
+		if param := params.Get(name); param != "" {
+			if *value, err = strconv.ParseBool(param); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid "+name+" value")
+				return
+			}
+		}
+	}
+	if limit := params.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit value")
+			return
+		}
+	}
+	for _, project := range params["project"] {
+		info, err := server.Manager.Find(project)
+		if err != nil {
+			writeError(w, statusOf(err), err.Error())
+			return
+		}
+		query.Projects = append(query.Projects, info.Path)
+	}
+	symbols := []Symbol{}
+	for _, result := range server.Manager.Tags.Search(query) {
+		symbols = append(symbols, Symbol{
+			Tag:     result.Tag,
+			Project: ProjectID(result.Project),
+			Path:    result.Project,
+			Score:   result.Score,
+		})
+	}
+	writeJSON(w, http.StatusOK, symbols)
+}
+
+// GET /api/v1/resolve?file=...
+// returns the status (including the tag files) of the project that
+// contains file
+func (server *Server) resolveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		writeError(w, http.StatusUnprocessableEntity, "file is required")
+		return
+	}
+	if !filepath.IsAbs(utils.Canonicalize(file)) {
+		writeError(w, http.StatusUnprocessableEntity, "file must be an absolute path")
+		return
+	}
+	status, err := server.Manager.Resolve(file)
+	if err != nil {
+		writeError(w, statusOf(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// the request body is optional; an empty body yields the default options
+func decodeReindexOptions(w http.ResponseWriter, r *http.Request) (ReindexOptions, bool) {
+	var options ReindexOptions
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return options, false
+	}
+	return options, true
+}
+
+// statusOf maps manager errors to http status codes
+func statusOf(err error) int {
+	switch err {
+	case ErrProjectNotFound:
+		return http.StatusNotFound
+	case ErrProjectExists, ErrProjectNested, ErrProjectCovered, ErrProjectInactive:
+		return http.StatusConflict
+	case ErrInvalidPath, ErrRelativePath:
+		return http.StatusUnprocessableEntity
+	case ErrManagerStopped:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Unable to write response: ", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{Error: message})
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// legacy (unversioned) interface; the path is sent in the request body
 func httpHandler(w http.ResponseWriter, r *http.Request, m *Manager) {
 	var project struct{ Path string }
 
 	switch r.Method {
 	case "GET":
 		projects := []struct{ Path string }{}
-		for path := range m.projects {
+		for _, path := range m.Paths() {
 			projects = append(projects, struct{ Path string }{Path: path})
 		}
 		json.NewEncoder(w).Encode(projects)
@@ -43,7 +552,10 @@ func httpHandler(w http.ResponseWriter, r *http.Request, m *Manager) {
 			return
 		}
 		log.Debug("Received POST for ", project.Path)
-		m.Add(project.Path)
+		if err := m.Add(project.Path); err != nil {
+			http.Error(w, err.Error(), statusOf(err))
+			return
+		}
 		w.WriteHeader(204)
 	case "DELETE":
 		if r.Body == nil {
@@ -56,9 +568,13 @@ func httpHandler(w http.ResponseWriter, r *http.Request, m *Manager) {
 			return
 		}
 		log.Debug("Received DELETE for ", project.Path)
-		m.Remove(project.Path)
+		if err := m.Remove(project.Path); err != nil {
+			http.Error(w, err.Error(), statusOf(err))
+			return
+		}
 		w.WriteHeader(204)
 	default:
-		http.Error(w, "Request can not be processed", 400)
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Request can not be processed", http.StatusMethodNotAllowed)
 	}
 }
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kkentzo/tagger/metrics"
+	"github.com/kkentzo/tagger/utils"
+	"github.com/kkentzo/tagger/watchers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func CreateTestServer(paths ...string) *Server {
+	watcher := CreateMockWatcher()
+	indexer := &MockIndexer{}
+	for _, path := range paths {
+		indexer.On("Create", path).Return(indexer)
+		indexer.On("CreateWatcher", path).Return(watcher)
+	}
+	manager := NewManager(indexer, []struct{ Path string }{})
+	return &Server{Manager: manager}
+}
+
+func Request(server *Server, method string, url string, body string) *httptest.ResponseRecorder {
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, url, nil)
+	} else {
+		r = httptest.NewRequest(method, url, strings.NewReader(body))
+	}
+	return Serve(server, r)
+}
+
+func Serve(server *Server, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func DecodeError(t *testing.T, w *httptest.ResponseRecorder) string {
+	var body struct{ Error string }
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+	return body.Error
+}
+
+func Test_Server_PostProject_ReturnsCreatedResource(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	server := CreateTestServer(path)
+
+	w := Request(server, "POST", "/api/v1/projects", `{"path":"`+path+`"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/api/v1/projects/"+ProjectID(path), w.Header().Get("Location"))
+	var info ProjectInfo
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&info))
+	assert.Equal(t, path, info.Path)
+	assert.Equal(t, ProjectID(path), info.ID)
+	assert.True(t, server.Manager.Exists(path))
+}
+
+func Test_Server_PostProject_ReturnsConflict_WhenProjectExists(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	server := CreateTestServer(path)
+	server.Manager.Add(path)
+
+	w := Request(server, "POST", "/api/v1/projects", `{"path":"`+path+`"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, ErrProjectExists.Error(), DecodeError(t, w))
+
+	w = Request(server, "POST", "/api/v1/projects", `{"path":"`+path+`/"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, []string{path}, server.Manager.Paths())
+}
+
+func Test_Server_PostProject_ReturnsUnprocessableEntity_OnInvalidPath(t *testing.T) {
+	server := CreateTestServer()
+	w := Request(server, "POST", "/api/v1/projects", `{"path":"/foo/bar"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, ErrInvalidPath.Error(), DecodeError(t, w))
+
+	w = Request(server, "POST", "/api/v1/projects", `{}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = Request(server, "POST", "/api/v1/projects", `{"path":"."}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, ErrRelativePath.Error(), DecodeError(t, w))
+
+	w = Request(server, "POST", "/api/v1/projects", `{`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_Server_GetProjects(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	server := CreateTestServer(path)
+	server.Manager.Add(path)
+
+	w := Request(server, "GET", "/api/v1/projects", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var projects []ProjectInfo
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&projects))
+	assert.Equal(t, []ProjectInfo{{ID: ProjectID(path), Path: path, State: StateWatching}}, projects)
+}
+
+func Test_Server_GetProject(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	server := CreateTestServer(path)
+	server.Manager.Add(path)
+
+	w := Request(server, "GET", "/api/v1/projects/"+ProjectID(path), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var info ProjectInfo
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&info))
+	assert.Equal(t, path, info.Path)
+
+	w = Request(server, "GET", "/api/v1/projects/foo", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ErrProjectNotFound.Error(), DecodeError(t, w))
+}
+
+func Test_Server_DeleteProject(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	server := CreateTestServer(path)
+	server.Manager.Add(path)
+
+	w := Request(server, "DELETE", "/api/v1/projects/"+ProjectID(path), "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.False(t, server.Manager.Exists(path))
+
+	w = Request(server, "DELETE", "/api/v1/projects/"+ProjectID(path), "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_Server_ReturnsMethodNotAllowed(t *testing.T) {
+	server := CreateTestServer()
+	w := Request(server, "PUT", "/api/v1/projects", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, POST", w.Header().Get("Allow"))
+
+	w = Request(server, "PUT", "/projects", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func Test_Server_LegacyPost_ReturnsError_OnInvalidPath(t *testing.T) {
+	server := CreateTestServer()
+	w := Request(server, "POST", "/projects", `{"Path":"/foo/bar"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func Test_Server_GetProjectStatus(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	server := CreateTestServer(path)
+	server.Manager.Add(path)
+
+	w := Request(server, "GET", "/api/v1/projects/"+ProjectID(path)+"/status", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var status ProjectStatus
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.Equal(t, path, status.Path)
+	assert.Equal(t, StateWatching, status.State)
+	assert.Empty(t, status.Runs)
+
+	w = Request(server, "GET", "/api/v1/projects/foo/status", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = Request(server, "GET", "/api/v1/projects/"+ProjectID(path)+"/foo", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = Request(server, "POST", "/api/v1/projects/"+ProjectID(path)+"/status", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func Test_Server_Resolve(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	server := CreateTestServer(path)
+	server.Manager.Add(path)
+
+	w := Request(server, "GET", "/api/v1/resolve?file="+url.QueryEscape(filepath.Join(path, "foo.go")), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var status ProjectStatus
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.Equal(t, path, status.Path)
+	assert.Equal(t, StateWatching, status.State)
+
+	w = Request(server, "GET", "/api/v1/resolve?file=/foo/bar.go", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = Request(server, "GET", "/api/v1/resolve?file=foo.go", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = Request(server, "GET", "/api/v1/resolve", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func Test_Server_Reindex(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	server := CreateTestServer(path)
+	server.Manager.Add(path)
+
+	// the manager has not been started
+	w := Request(server, "POST", "/api/v1/projects/"+ProjectID(path)+"/reindex", `{"full":true}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, ErrProjectInactive.Error(), DecodeError(t, w))
+
+	w = Request(server, "POST", "/api/v1/projects/"+ProjectID(path)+"/reindex", `{`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = Request(server, "GET", "/api/v1/projects/"+ProjectID(path)+"/reindex", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = Request(server, "POST", "/api/v1/reindex", "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "[]\n", w.Body.String())
+	w = Request(server, "GET", "/api/v1/reindex", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func Test_Server_Events_StreamsProjectEvents(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	server := CreateTestServer(path)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/events?project=" + ProjectID(path))
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	server.Manager.Add(path)
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "event: project_added\n", line)
+	line, err = reader.ReadString('\n')
+	assert.Nil(t, err)
+	var event ProjectEvent
+	assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+	assert.Equal(t, path, event.Path)
+	assert.Equal(t, ProjectID(path), event.Project)
+}
+
+func Test_Server_Listen_ServesOnUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "run", "tagger.sock")
+	// a stale socket file is replaced
+	assert.Nil(t, os.MkdirAll(filepath.Dir(socket), 0700))
+	stale, err := net.Listen("unix", socket)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	server := NewServer(NewManager(&MockIndexer{}, []struct{ Path string }{}), Listen{Socket: socket}, "")
+	failed := make(chan error, 1)
+	go func() { failed <- server.Listen() }()
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, address string) (net.Conn, error) { return net.Dial("unix", socket) },
+	}}
+	var resp *http.Response
+	WaitFor(t, func() bool {
+		resp, err = client.Get("http://tagger/api/v1/projects")
+		return err == nil
+	})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	info, err := os.Stat(socket)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// a second instance must not steal the socket
+	assert.NotNil(t, NewServer(server.Manager, Listen{Socket: socket}, "").Listen())
+
+	assert.Nil(t, server.Shutdown(context.Background()))
+	assert.Nil(t, <-failed)
+	assert.False(t, utils.FileExists(socket))
+}
+
+func Test_Server_Listen_RejectsSharedDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, os.Chmod(dir, 0777))
+
+	server := NewServer(NewManager(&MockIndexer{}, []struct{ Path string }{}),
+		Listen{Socket: filepath.Join(dir, "tagger.sock")}, "")
+	assert.NotNil(t, server.Listen())
+	assert.False(t, utils.FileExists(filepath.Join(dir, "tagger.sock")))
+}
+
+func Test_DefaultSocketPath_IsInAPrivateDirectory(t *testing.T) {
+	defer os.Setenv("XDG_RUNTIME_DIR", os.Getenv("XDG_RUNTIME_DIR"))
+	os.Setenv("XDG_RUNTIME_DIR", "/run/user/1")
+	assert.Equal(t, "/run/user/1/tagger.sock", DefaultSocketPath())
+	os.Unsetenv("XDG_RUNTIME_DIR")
+	assert.Equal(t, filepath.Join(os.TempDir(), fmt.Sprintf("tagger-%d", os.Getuid()), "tagger.sock"),
+		DefaultSocketPath())
+}
+
+func Test_Server_Listen_WillNotRemoveFile_WhenItIsNotASocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "tagger.sock")
+	TouchFile(t, socket).Close()
+
+	server := NewServer(NewManager(&MockIndexer{}, []struct{ Path string }{}), Listen{Socket: socket}, "")
+	assert.EqualError(t, server.Listen(), socket+" exists and is not a socket")
+	assert.True(t, utils.FileExists(socket))
+}
+
+func Test_Server_Metrics_ExposesIndexingMetrics(t *testing.T) {
+	// the registry is shared by all tests (and runs)
+	metrics.Default.DeleteLabel("project", "/foo/metrics")
+	indexer := &MockIndexer{}
+	indexer.On("Index", "/foo/metrics", mock.AnythingOfType("watchers.Event")).Return(errors.New("foo"))
+	project := DefaultProject(indexer, &MockWatcher{})
+	project.Path = "/foo/metrics"
+	project.Index(watchers.NewEvent())
+
+	w := Request(CreateTestServer(), "GET", "/metrics", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `tagger_indexing_failures_total{project="/foo/metrics"} 1`)
+	assert.Contains(t, w.Body.String(), `tagger_indexing_duration_seconds_count{project="/foo/metrics"} 1`)
+	assert.Contains(t, w.Body.String(), "# TYPE tagger_fs_watches gauge")
+}
+
+func Test_Server_Healthz(t *testing.T) {
+	w := Request(CreateTestServer(), "GET", "/healthz", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{\"status\":\"ok\"}\n", w.Body.String())
+}
+
+func Test_Server_Readyz_WaitsForInitialIndexing(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	server := CreateTestServer(path)
+	release := make(chan struct{})
+	server.Manager.indexer.(*MockIndexer).On("Index", path, mock.AnythingOfType("watchers.Event")).
+		Run(func(args mock.Arguments) { <-release })
+	server.Manager.Add(path)
+
+	w := Request(server, "GET", "/readyz", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	server.Manager.Start(context.Background())
+	defer server.Manager.Shutdown(context.Background())
+	w = Request(server, "GET", "/readyz", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "{\"ready\":false,\"pending\":[\""+path+"\"]}\n", w.Body.String())
+
+	close(release)
+	WaitFor(t, func() bool { return len(server.Manager.Pending()) == 0 })
+	w = Request(server, "GET", "/readyz", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func Test_Server_Pprof_IsMountedOnlyWhenEnabled(t *testing.T) {
+	server := CreateTestServer()
+	w := Request(server, "GET", "/debug/pprof/", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	server.Profiling = true
+	w = Request(server, "GET", "/debug/pprof/", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func Test_Server_GetSymbols(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	tagFile := filepath.Join(path, "tags")
+	assert.Nil(t, ioutil.WriteFile(tagFile, []byte("Foo\tfoo.go\t3;\"\tf\nFooBar\tbar.go\t7;\"\tt\n"), 0644))
+	server := CreateTestServer(path)
+	server.Manager.Add(path)
+	assert.Nil(t, server.Manager.Tags.Load(path, tagFile))
+
+	w := Request(server, "GET", "/api/v1/symbols?name=Foo&prefix=true&kind=f&project="+ProjectID(path), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var symbols []Symbol
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&symbols))
+	assert.Len(t, symbols, 1)
+	assert.Equal(t, filepath.Join(path, "foo.go"), symbols[0].File)
+	assert.Equal(t, 3, symbols[0].Line)
+	assert.Equal(t, ProjectID(path), symbols[0].Project)
+	assert.Equal(t, path, symbols[0].Path)
+
+	w = Request(server, "GET", "/api/v1/symbols?name=Foo&prefix=true&limit=1", "")
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&symbols))
+	assert.Len(t, symbols, 1)
+
+	w = Request(server, "GET", "/api/v1/symbols?name=foobar&ignore_case=true", "")
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&symbols))
+	assert.Len(t, symbols, 1)
+	assert.Equal(t, "FooBar", symbols[0].Name)
+
+	w = Request(server, "GET", "/api/v1/symbols?name=fb&fuzzy=true", "")
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&symbols))
+	assert.Len(t, symbols, 1)
+	assert.Equal(t, "FooBar", symbols[0].Name)
+	assert.True(t, symbols[0].Score > 0)
+
+	w = Request(server, "GET", "/api/v1/symbols", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = Request(server, "GET", "/api/v1/symbols?name=Foo&fuzzy=maybe", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = Request(server, "GET", "/api/v1/symbols?name=Foo&prefix=maybe", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = Request(server, "GET", "/api/v1/symbols?name=Foo&project=foo", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/kkentzo/tagger/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// State records the changes made to the project set at runtime (e.g.
+// through the http interface) relative to the config file, so that they
+// survive restarts. Only the deltas are stored so that subsequent edits
+// of the config file still take effect.
+type State struct {
+	// projects added at runtime
+	Added []string `yaml:"added,omitempty"`
+	// config projects removed at runtime
+	Removed []string `yaml:"removed,omitempty"`
+	Paused  []string `yaml:"paused,omitempty"`
+
+	path string
+	// when set, additions and removals are written to the projects list
+	// of this config file instead
+	configFilePath string
+	mu             sync.Mutex
+}
+
+func DefaultStateFilePath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "state")
+	}
+	return filepath.Join(dir, "tagger", "state.yml")
+}
+
+// LoadState reads the state file at path; a missing file yields an
+// empty state. If configFilePath is not empty, project additions and
+// removals will be written back to that config file.
+func LoadState(path string, configFilePath string) (*State, error) {
+	state := &State{path: path, configFilePath: configFilePath}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(contents, state); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %s", path, err.Error())
+	}
+	for _, paths := range [][]string{state.Added, state.Removed, state.Paused} {
+		sort.Strings(paths)
+	}
+	return state, nil
+}
+
+// Merge applies the recorded changes to the projects of a config file
+func (state *State) Merge(projects []struct{ Path string }) []struct{ Path string } {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	removed := utils.NewSet(state.Removed)
+	merged := []struct{ Path string }{}
+	seen := utils.NewSet([]string{})
+	for _, p := range projects {
+		path := projectPath(p.Path)
+		if removed.Has(path) || seen.Has(path) {
+			continue
+		}
+		seen.Add(path)
+		merged = append(merged, struct{ Path string }{Path: path})
+	}
+	for _, path := range state.Added {
+		if !seen.Has(path) {
+			seen.Add(path)
+			merged = append(merged, struct{ Path string }{Path: path})
+		}
+	}
+	return merged
+}
+
+func (state *State) IsPaused(path string) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return contains(state.Paused, path)
+}
+
+func (state *State) IsRemoved(path string) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return contains(state.Removed, path)
+}
+
+func (state *State) RecordAdd(path string) error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.Removed = without(state.Removed, path)
+	if state.configFilePath != "" {
+		if _, err := state.updateConfig(path, true); err != nil {
+			return err
+		}
+	} else {
+		state.Added = with(state.Added, path)
+	}
+	return state.save()
+}
+
+func (state *State) RecordRemove(path string) error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.Paused = without(state.Paused, path)
+	if state.configFilePath != "" {
+		state.Added = without(state.Added, path)
+		found, err := state.updateConfig(path, false)
+		if err != nil {
+			return err
+		}
+		// e.g. a project of an included config file
+		if !found {
+			state.Removed = with(state.Removed, path)
+		}
+	} else if contains(state.Added, path) {
+		state.Added = without(state.Added, path)
+	} else {
+		state.Removed = with(state.Removed, path)
+	}
+	return state.save()
+}
+
+func (state *State) RecordPause(path string, paused bool) error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if paused {
+		state.Paused = with(state.Paused, path)
+	} else {
+		state.Paused = without(state.Paused, path)
+	}
+	return state.save()
+}
+
+func (state *State) save() error {
+	if err := os.MkdirAll(filepath.Dir(state.path), 0700); err != nil {
+		return err
+	}
+	contents, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(state.path, contents, 0600)
+}
+
+// updateConfig adds (or removes) path to the projects list of the config
+// file and returns true if it was listed; note that comments in the
+// config file are not preserved
+func (state *State) updateConfig(path string, add bool) (bool, error) {
+	contents, err := ioutil.ReadFile(state.configFilePath)
+	if err != nil {
+		return false, err
+	}
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(contents, &doc); err != nil {
+		return false, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(contents, config); err != nil {
+		return false, err
+	}
+	projects := []map[string]string{}
+	found := false
+	for _, p := range config.Projects {
+		if filepath.Clean(utils.ExpandPath(p.Path)) == path {
+			found = true
+			if !add {
+				continue
+			}
+		}
+		projects = append(projects, map[string]string{"path": p.Path})
+	}
+	if add && !found {
+		projects = append(projects, map[string]string{"path": path})
+	}
+	replaced := false
+	for i := range doc {
+		if doc[i].Key == "projects" {
+			doc[i].Value = projects
+			replaced = true
+		}
+	}
+	if !replaced {
+		doc = append(doc, yaml.MapItem{Key: "projects", Value: projects})
+	}
+	contents, err = yaml.Marshal(doc)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(state.configFilePath)
+	if err != nil {
+		return false, err
+	}
+	return found, utils.WriteFileAtomic(state.configFilePath, contents, info.Mode().Perm())
+}
+
+// helpers for sorted string slices
+
+func contains(elements []string, element string) bool {
+	i := sort.SearchStrings(elements, element)
+	return i < len(elements) && elements[i] == element
+}
+
+func with(elements []string, element string) []string {
+	if contains(elements, element) {
+		return elements
+	}
+	elements = append(elements, element)
+	sort.Strings(elements)
+	return elements
+}
+
+func without(elements []string, element string) []string {
+	result := []string{}
+	for _, e := range elements {
+		if e != element {
+			result = append(result, e)
+		}
+	}
+	return result
+}
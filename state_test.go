@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DefaultStateFilePath_UsesXdgStateHome(t *testing.T) {
+	defer os.Setenv("XDG_STATE_HOME", os.Getenv("XDG_STATE_HOME"))
+	os.Setenv("XDG_STATE_HOME", "/xdg")
+	assert.Equal(t, "/xdg/tagger/state.yml", DefaultStateFilePath())
+	os.Setenv("XDG_STATE_HOME", "")
+	assert.Equal(t, filepath.Join(os.Getenv("HOME"), ".local/state/tagger/state.yml"),
+		DefaultStateFilePath())
+}
+
+func Test_LoadState_ReturnsEmptyState_WhenFileDoesNotExist(t *testing.T) {
+	state, err := LoadState("/foo/state.yml", "")
+	assert.Nil(t, err)
+	assert.Empty(t, state.Added)
+	assert.Empty(t, state.Removed)
+}
+
+func Test_State_IsPersisted(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	fname := filepath.Join(path, "tagger", "state.yml")
+
+	state, err := LoadState(fname, "")
+	assert.Nil(t, err)
+	assert.Nil(t, state.RecordAdd("/a"))
+	assert.Nil(t, state.RecordRemove("/b"))
+	assert.Nil(t, state.RecordPause("/a", true))
+
+	state, err = LoadState(fname, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/a"}, state.Added)
+	assert.Equal(t, []string{"/b"}, state.Removed)
+	assert.True(t, state.IsPaused("/a"))
+}
+
+func Test_State_RecordRemove_ForgetsRuntimeAddition(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	state, err := LoadState(filepath.Join(path, "state.yml"), "")
+	assert.Nil(t, err)
+	assert.Nil(t, state.RecordAdd("/a"))
+	assert.Nil(t, state.RecordRemove("/a"))
+	assert.Empty(t, state.Added)
+	assert.Empty(t, state.Removed)
+}
+
+func Test_State_Merge(t *testing.T) {
+	state := &State{Added: []string{"/c", "/a"}, Removed: []string{"/b"}}
+	projects := []struct{ Path string }{{Path: "/a"}, {Path: "/b"}, {Path: "/d"}}
+	assert.Equal(t,
+		[]struct{ Path string }{{Path: "/a"}, {Path: "/d"}, {Path: "/c"}},
+		state.Merge(projects))
+}
+
+func Test_State_Merge_CleansTheConfiguredPaths(t *testing.T) {
+	state := &State{Removed: []string{"/b"}}
+	projects := []struct{ Path string }{{Path: "/a/"}, {Path: "/b/"}, {Path: "/a"}}
+	assert.Equal(t, []struct{ Path string }{{Path: "/a"}}, state.Merge(projects))
+}
+
+func Test_State_RecordAddAndRemove_UpdateConfigFile(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	configFilePath := filepath.Join(path, "tagger.yml")
+	WriteConfig(t, configFilePath, "port: 1234\nprojects:\n  - path: /a\n")
+	state, err := LoadState(filepath.Join(path, "state.yml"), configFilePath)
+	assert.Nil(t, err)
+
+	assert.Nil(t, state.RecordAdd("/b"))
+	// the config lacks an indexer, so only the decoding must succeed
+	config, _ := LoadConfig(configFilePath)
+	assert.NotNil(t, config)
+	assert.Equal(t, 1234, config.Port)
+	assert.Equal(t, []struct{ Path string }{{Path: "/a"}, {Path: "/b"}}, config.Projects)
+
+	assert.Nil(t, state.RecordRemove("/a"))
+	config, _ = LoadConfig(configFilePath)
+	assert.NotNil(t, config)
+	assert.Equal(t, []struct{ Path string }{{Path: "/b"}}, config.Projects)
+	assert.Empty(t, state.Added)
+	assert.Empty(t, state.Removed)
+}
+
+func Test_State_RecordRemove_RemembersProjectsOfIncludedFiles(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	configFilePath := filepath.Join(path, "tagger.yml")
+	WriteConfig(t, configFilePath, "include:\n  - team.yml\nprojects:\n  - path: /a\n")
+	state, err := LoadState(filepath.Join(path, "state.yml"), configFilePath)
+	assert.Nil(t, err)
+
+	assert.Nil(t, state.RecordRemove("/b"))
+	assert.Equal(t, []string{"/b"}, state.Removed)
+	assert.Nil(t, state.RecordAdd("/b"))
+	assert.Empty(t, state.Removed)
+}
+
+func Test_State_RecordRemove_UpdatesConfigFile_WhenPathHasTrailingSlash(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	configFilePath := filepath.Join(path, "tagger.yml")
+	WriteConfig(t, configFilePath, "projects:\n  - path: /a/\n  - path: /b\n")
+	state, err := LoadState(filepath.Join(path, "state.yml"), configFilePath)
+	assert.Nil(t, err)
+
+	assert.Nil(t, state.RecordRemove("/a"))
+	config, _ := LoadConfig(configFilePath)
+	assert.NotNil(t, config)
+	assert.Equal(t, []struct{ Path string }{{Path: "/b"}}, config.Projects)
+	assert.Empty(t, state.Removed)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kkentzo/tagger/utils"
+)
+
+// the number of indexing runs kept in the history of a project
+var HistorySize = 20
+
+// the states of a project as reported by the status endpoint
+const (
+	// monitored but not indexed yet
+	StateWatching = "watching"
+	StateIndexing = "indexing"
+	// the last indexing run succeeded
+	StateIdle = "idle"
+	// the last indexing run failed
+	StateFailed  = "failed"
+	StateMissing = "missing"
+	StatePaused  = "paused"
+)
+
+// Run records a single indexing run
+type Run struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	DurationMs int64     `json:"duration_ms"`
+	// -1 if the indexer could not be run or was killed
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+	// the output of the failed indexer (ctags reports errors on stderr)
+	Output string `json:"output,omitempty"`
+}
+
+// TagFileInfo describes a tag file produced for a project
+type TagFileInfo struct {
+	Path     string    `json:"path"`
+	Exists   bool      `json:"exists"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Entries  int       `json:"entries"`
+}
+
+// Status keeps the indexing history of a project; it outlives the
+// project's restarts so that the history is not lost
+type Status struct {
+	mu      sync.Mutex
+	running int
+	// the most recent runs, oldest first
+	runs []Run
+	// the entry counts of tag files keyed by path
+	entries map[string]TagFileInfo
+}
+
+func NewStatus() *Status {
+	return &Status{entries: make(map[string]TagFileInfo)}
+}
+
+// Begin marks the start of an indexing run
+func (status *Status) Begin() time.Time {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.running++
+	return time.Now()
+}
+
+// End records (and returns) the outcome of the indexing run that started
+// at start
+func (status *Status) End(start time.Time, err error) Run {
+	end := time.Now()
+	run := Run{
+		Start:      start,
+		End:        end,
+		DurationMs: int64(end.Sub(start) / time.Millisecond),
+	}
+	if err != nil {
+		run.ExitCode = -1
+		run.Error = err.Error()
+		if execErr, ok := err.(*utils.ExecError); ok {
+			run.ExitCode = execErr.ExitCode
+			run.Error = execErr.Err.Error()
+			run.Output = string(execErr.Output)
+		}
+	}
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.running--
+	status.runs = append(status.runs, run)
+	if len(status.runs) > HistorySize {
+		status.runs = status.runs[len(status.runs)-HistorySize:]
+	}
+	return run
+}
+
+// State returns the indexing state (one of watching, indexing, idle or
+// failed)
+func (status *Status) State() string {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	switch {
+	case status.running > 0:
+		return StateIndexing
+	case len(status.runs) == 0:
+		return StateWatching
+	case status.runs[len(status.runs)-1].ExitCode != 0:
+		return StateFailed
+	default:
+		return StateIdle
+	}
+}
+
+// LastRun returns the most recent run or nil if there is none
+func (status *Status) LastRun() *Run {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	if len(status.runs) == 0 {
+		return nil
+	}
+	run := status.runs[len(status.runs)-1]
+	return &run
+}
+
+// Runs returns the recorded runs, most recent first
+func (status *Status) Runs() []Run {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	runs := make([]Run, len(status.runs))
+	for i, run := range status.runs {
+		runs[len(runs)-1-i] = run
+	}
+	return runs
+}
+
+// TagFile describes the tag file at path; entries are counted only when
+// the file has changed since the last call
+func (status *Status) TagFile(path string) TagFileInfo {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return TagFileInfo{Path: path}
+	}
+	info := TagFileInfo{Path: path, Exists: true, Size: fi.Size(), Modified: fi.ModTime()}
+	status.mu.Lock()
+	cached, ok := status.entries[path]
+	status.mu.Unlock()
+	if ok && cached.Size == info.Size && cached.Modified.Equal(info.Modified) {
+		return cached
+	}
+	entries, err := countTags(path)
+	if err != nil {
+		return info
+	}
+	info.Entries = entries
+	status.mu.Lock()
+	status.entries[path] = info
+	status.mu.Unlock()
+	return info
+}
+
+// countTags counts the entries of an etags (one DEL character per tag
+// definition) or ctags (one line per tag, excluding pseudo-tags) file
+func countTags(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	first, err := reader.Peek(1)
+	if err != nil {
+		// empty file
+		return 0, nil
+	}
+	count := 0
+	if first[0] == '\x0c' {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := reader.Read(buf)
+			count += bytes.Count(buf[:n], []byte{'\x7f'})
+			if err == io.EOF {
+				return count, nil
+			} else if err != nil {
+				return 0, err
+			}
+		}
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) > 0 && !bytes.HasPrefix(line, []byte("!_")) {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Status_State(t *testing.T) {
+	status := NewStatus()
+	assert.Equal(t, StateWatching, status.State())
+	start := status.Begin()
+	assert.Equal(t, StateIndexing, status.State())
+	status.End(start, nil)
+	assert.Equal(t, StateIdle, status.State())
+	status.End(status.Begin(), errors.New("foo"))
+	assert.Equal(t, StateFailed, status.State())
+	assert.Equal(t, -1, status.LastRun().ExitCode)
+	assert.Equal(t, "foo", status.LastRun().Error)
+}
+
+func Test_Status_Runs_AreBounded(t *testing.T) {
+	defer func(size int) { HistorySize = size }(HistorySize)
+	HistorySize = 2
+	status := NewStatus()
+	for _, err := range []error{errors.New("a"), errors.New("b"), nil} {
+		status.End(status.Begin(), err)
+	}
+	runs := status.Runs()
+	assert.Len(t, runs, 2)
+	assert.Equal(t, "", runs[0].Error)
+	assert.Equal(t, "b", runs[1].Error)
+}
+
+func Test_countTags_CountsCtagsEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tags")
+	contents := "!_TAG_FILE_FORMAT\t2\t//\nBar\tfoo.go\t/^func Bar(/;\"\tf\nFoo\tfoo.go\t/^func Foo(/;\"\tf\n"
+	assert.Nil(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	count, err := countTags(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func Test_Status_TagFile_ReportsMissingFile(t *testing.T) {
+	info := NewStatus().TagFile("/foo/bar/TAGS")
+	assert.Equal(t, TagFileInfo{Path: "/foo/bar/TAGS"}, info)
+}
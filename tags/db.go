@@ -0,0 +1,161 @@
+package tags
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Query specifies a symbol search
+type Query struct {
+	Name   string
+	Prefix bool
+	// compare names regardless of case
+	IgnoreCase bool
+	// match the characters of name as a subsequence (regardless of case)
+	// and rank the results by score
+	Fuzzy bool
+	// only tags of this kind (if not empty)
+	Kind string
+	// only tags of these projects (all if empty)
+	Projects []string
+	// the maximum number of results (unlimited if 0)
+	Limit int
+}
+
+// Result is a tag found by a search
+type Result struct {
+	Tag
+	// the root of the project the tag belongs to
+	Project string `json:"project"`
+	// fuzzy searches only
+	Score int `json:"score,omitempty"`
+}
+
+// DB holds the indices of multiple projects keyed by their root; it is
+// safe for concurrent use
+type DB struct {
+	mu      sync.RWMutex
+	indices map[string]*Index
+}
+
+func NewDB() *DB {
+	return &DB{indices: make(map[string]*Index)}
+}
+
+// Load (re)places the index of the project at root with the contents of
+// the tag file at path; the current index is kept if parsing fails
+func (db *DB) Load(root string, path string) error {
+	tags, err := ParseFile(path)
+	if err != nil {
+		return err
+	}
+	db.Set(root, tags)
+	return nil
+}
+
+// Set (re)places the index of the project at root with tags
+func (db *DB) Set(root string, tags []Tag) {
+	index := NewIndex(tags)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.indices[root] = index
+}
+
+// Update refreshes the index of the project at root after the given
+// files or directories (absolute paths) have been re-tagged: only their
+// tags are read from the tag file at path and replace the existing ones.
+// The whole tag file is loaded if there is no index yet.
+func (db *DB) Update(root string, path string, changed []string) error {
+	db.mu.RLock()
+	index, ok := db.indices[root]
+	db.mu.RUnlock()
+	if !ok || len(changed) == 0 {
+		return db.Load(root, path)
+	}
+	replaced := func(file string) bool {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(root, file)
+		}
+		for _, c := range changed {
+			if file == c || strings.HasPrefix(file, c+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+	tags, err := ParseFileFiltered(path, replaced)
+	if err != nil {
+		return err
+	}
+	updated := index.Replace(replaced, tags)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.indices[root] = updated
+	return nil
+}
+
+func (db *DB) Remove(root string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.indices, root)
+}
+
+// Stats returns the size of the index of the project at root
+func (db *DB) Stats(root string) (Stats, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	index, ok := db.indices[root]
+	if !ok {
+		return Stats{}, false
+	}
+	return index.Stats(), true
+}
+
+// Search returns the tags matching query sorted by project and name (or
+// by decreasing score for fuzzy searches); files are made absolute using
+// the project roots
+func (db *DB) Search(query Query) []Result {
+	db.mu.RLock()
+	roots := query.Projects
+	if len(roots) == 0 {
+		for root := range db.indices {
+			roots = append(roots, root)
+		}
+	}
+	sort.Strings(roots)
+	indices := []*Index{}
+	for _, root := range roots {
+		indices = append(indices, db.indices[root])
+	}
+	db.mu.RUnlock()
+
+	results := []Result{}
+	for i, index := range indices {
+		if index == nil {
+			continue
+		}
+		tags, scores := index.search(query)
+		for j, tag := range tags {
+			if !filepath.IsAbs(tag.File) {
+				tag.File = filepath.Join(roots[i], tag.File)
+			}
+			result := Result{Tag: tag, Project: roots[i]}
+			if query.Fuzzy {
+				result.Score = scores[j]
+			}
+			results = append(results, result)
+			if !query.Fuzzy && query.Limit > 0 && len(results) == query.Limit {
+				return results
+			}
+		}
+	}
+	if query.Fuzzy {
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+		if query.Limit > 0 && len(results) > query.Limit {
+			results = results[:query.Limit]
+		}
+	}
+	return results
+}
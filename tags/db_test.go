@@ -0,0 +1,102 @@
+package tags
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Index_Lookup(t *testing.T) {
+	index := NewIndex([]Tag{{Name: "foobar"}, {Name: "bar"}, {Name: "foo", Line: 1}, {Name: "foo", Line: 2}})
+	assert.Equal(t, []Tag{{Name: "foo", Line: 1}, {Name: "foo", Line: 2}}, index.Lookup("foo", false))
+	assert.Len(t, index.Lookup("foo", true), 3)
+	assert.Empty(t, index.Lookup("baz", true))
+}
+
+func Test_DB_Search(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tags")
+	contents := "Foo\tfoo.go\t3;\"\tf\nFoo\t/abs/foo.go\t5;\"\tt\nFooBar\tbar.go\t7;\"\tf\n"
+	assert.Nil(t, ioutil.WriteFile(path, []byte(contents), 0644))
+
+	db := NewDB()
+	assert.Nil(t, db.Load("/a", path))
+	assert.Nil(t, db.Load("/b", path))
+	assert.NotNil(t, db.Load("/c", filepath.Join(dir, "missing")))
+
+	results := db.Search(Query{Name: "Foo", Kind: "f", Projects: []string{"/a"}})
+	assert.Equal(t, []Result{{Tag: Tag{Name: "Foo", File: "/a/foo.go", Line: 3, Kind: "f"}, Project: "/a"}}, results)
+	assert.Len(t, db.Search(Query{Name: "Foo"}), 4)
+	assert.Len(t, db.Search(Query{Name: "Foo", Prefix: true}), 6)
+	assert.Len(t, db.Search(Query{Name: "Foo", Prefix: true, Limit: 2}), 2)
+	assert.Equal(t, "/abs/foo.go", db.Search(Query{Name: "Foo", Kind: "t"})[0].File)
+
+	db.Remove("/a")
+	assert.Len(t, db.Search(Query{Name: "Foo"}), 2)
+}
+
+func Test_Index_Search_IgnoreCase(t *testing.T) {
+	index := NewIndex([]Tag{{Name: "FooBar"}, {Name: "foo"}, {Name: "Bar"}, {Name: "FOO"}})
+	assert.Len(t, index.Search(Query{Name: "foo", IgnoreCase: true}), 2)
+	assert.Len(t, index.Search(Query{Name: "FOO", IgnoreCase: true, Prefix: true}), 3)
+	assert.Len(t, index.Search(Query{Name: "foo"}), 1)
+}
+
+func Test_Index_Search_Fuzzy(t *testing.T) {
+	index := NewIndex([]Tag{{Name: "fabulous"}, {Name: "FooBar"}, {Name: "xyz"}, {Name: "a_foo_bar"}})
+	tags, scores := index.search(Query{Name: "fb", Fuzzy: true})
+	assert.Len(t, tags, 3)
+	assert.Equal(t, "FooBar", tags[0].Name)
+	assert.True(t, scores[0] >= scores[1] && scores[1] >= scores[2])
+	assert.Empty(t, index.Search(Query{Name: "zz", Fuzzy: true}))
+}
+
+func Test_fuzzyScore(t *testing.T) {
+	assert.Equal(t, -1, fuzzyScore("ba", "ab"))
+	assert.True(t, fuzzyScore("foo", "foo") > fuzzyScore("foo", "foobar"))
+	assert.True(t, fuzzyScore("fb", "fooBar") > fuzzyScore("fb", "fooxbar"))
+}
+
+func Test_DB_Update_ReplacesOnlyChangedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "TAGS")
+	write := func(foo string, bar string) {
+		sections := map[string]string{"foo.go": foo, "lib/bar.go": bar}
+		contents := ""
+		for _, file := range []string{"foo.go", "lib/bar.go"} {
+			body := sections[file]
+			contents += "\x0c\n" + file + "," + strconv.Itoa(len(body)) + "\n" + body
+		}
+		assert.Nil(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	}
+	write("func Foo(\x7fFoo\x011,0\n", "func Bar(\x7fBar\x011,0\n")
+
+	db := NewDB()
+	assert.Nil(t, db.Update(dir, path, []string{filepath.Join(dir, "foo.go")}))
+	assert.Len(t, db.Search(Query{Name: "Bar"}), 1)
+
+	// the contents of foo.go are stale, but it has not changed
+	write("func Foo2(\x7fFoo2\x011,0\n", "func Baz(\x7fBaz\x012,0\n")
+	assert.Nil(t, db.Update(dir, path, []string{filepath.Join(dir, "lib")}))
+	assert.Len(t, db.Search(Query{Name: "Foo"}), 1)
+	assert.Empty(t, db.Search(Query{Name: "Foo2"}))
+	assert.Empty(t, db.Search(Query{Name: "Bar"}))
+	assert.Equal(t, []Result{{Tag: Tag{Name: "Baz", File: filepath.Join(dir, "lib/bar.go"), Line: 2}, Project: dir}},
+		db.Search(Query{Name: "Baz"}))
+
+	stats, ok := db.Stats(dir)
+	assert.True(t, ok)
+	assert.Equal(t, 2, stats.Tags)
+	assert.Equal(t, 2, stats.Files)
+	assert.True(t, stats.Memory > 0)
+	_, ok = db.Stats("/missing")
+	assert.False(t, ok)
+}
@@ -0,0 +1,62 @@
+package tags
+
+// fuzzyScore matches the characters of the (lowercase) pattern as a
+// subsequence of name (ignoring case) and returns -1 if there is no match
+// or a score that favours consecutive characters, matches at the start
+// of name or of its words and short names
+func fuzzyScore(pattern string, name string) int {
+	if len(pattern) == 0 {
+		return 0
+	}
+	score := 0
+	last := -1
+	p := 0
+	for i := 0; i < len(name) && p < len(pattern); i++ {
+		if toLower(name[i]) != pattern[p] {
+			continue
+		}
+		score += 1
+		switch {
+		case i == 0:
+			score += 8
+		case last == i-1:
+			score += 5
+		case isBoundary(name, i):
+			score += 3
+		}
+		if last >= 0 && last < i-1 {
+			// penalize gaps (but not too much)
+			gap := i - last - 1
+			if gap > 3 {
+				gap = 3
+			}
+			score -= gap
+		}
+		last = i
+		p++
+	}
+	if p < len(pattern) {
+		return -1
+	}
+	if len(name) == len(pattern) {
+		score += 10
+	}
+	return score - (len(name)-len(pattern))/4
+}
+
+// isBoundary returns true if name[i] starts a word (after a separator or
+// at a lower to upper case transition)
+func isBoundary(name string, i int) bool {
+	previous, current := name[i-1], name[i]
+	if previous == '_' || previous == '-' || previous == '.' || previous == ':' {
+		return true
+	}
+	return previous >= 'a' && previous <= 'z' && current >= 'A' && current <= 'Z'
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
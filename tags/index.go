@@ -0,0 +1,218 @@
+package tags
+
+import (
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// entry is the compact form of a tag; file names and kinds are shared
+// by all the entries of an index
+type entry struct {
+	name string
+	file int32
+	kind int32
+	line int32
+}
+
+// Index holds the tags of a single project sorted by name; it is
+// immutable so that it can be searched while a new one is being built
+type Index struct {
+	entries []entry
+	// the positions of entries sorted by their lowercase names
+	folded []int32
+	files  []string
+	kinds  []string
+	memory int64
+}
+
+// Stats describes the size of an index
+type Stats struct {
+	Tags  int `json:"tags"`
+	Files int `json:"files"`
+	// the approximate memory used by the index (in bytes)
+	Memory int64 `json:"memory"`
+}
+
+func NewIndex(tags []Tag) *Index {
+	builder := newBuilder()
+	entries := make([]entry, 0, len(tags))
+	for _, tag := range tags {
+		entries = append(entries, builder.entry(tag))
+	}
+	sortEntries(entries)
+	return builder.build(entries)
+}
+
+func (index *Index) Len() int {
+	return len(index.entries)
+}
+
+func (index *Index) Stats() Stats {
+	return Stats{Tags: len(index.entries), Files: len(index.files), Memory: index.memory}
+}
+
+// Lookup returns the tags named name (or starting with name if prefix is
+// set)
+func (index *Index) Lookup(name string, prefix bool) []Tag {
+	return index.Search(Query{Name: name, Prefix: prefix})
+}
+
+// Search returns the tags of the index that match the name, kind and
+// mode of query (its projects and limit are ignored); fuzzy matches are
+// returned in order of decreasing score along with their scores
+func (index *Index) Search(query Query) []Tag {
+	tags, _ := index.search(query)
+	return tags
+}
+
+func (index *Index) search(query Query) ([]Tag, []int) {
+	tags := []Tag{}
+	scores := []int{}
+	matches := func(e entry) bool {
+		return query.Kind == "" || index.kinds[e.kind] == query.Kind
+	}
+	switch {
+	case query.Fuzzy:
+		pattern := strings.ToLower(query.Name)
+		for _, e := range index.entries {
+			if score := fuzzyScore(pattern, e.name); score >= 0 && matches(e) {
+				tags = append(tags, index.tag(e))
+				scores = append(scores, score)
+			}
+		}
+		sort.Stable(byScore{tags, scores})
+	case query.IgnoreCase:
+		name := strings.ToLower(query.Name)
+		start := sort.Search(len(index.folded), func(i int) bool {
+			return strings.ToLower(index.entries[index.folded[i]].name) >= name
+		})
+		for _, position := range index.folded[start:] {
+			e := index.entries[position]
+			folded := strings.ToLower(e.name)
+			if folded != name && !(query.Prefix && strings.HasPrefix(folded, name)) {
+				break
+			}
+			if matches(e) {
+				tags = append(tags, index.tag(e))
+			}
+		}
+	default:
+		start := sort.Search(len(index.entries), func(i int) bool {
+			return index.entries[i].name >= query.Name
+		})
+		for _, e := range index.entries[start:] {
+			if e.name != query.Name && !(query.Prefix && strings.HasPrefix(e.name, query.Name)) {
+				break
+			}
+			if matches(e) {
+				tags = append(tags, index.tag(e))
+			}
+		}
+	}
+	return tags, scores
+}
+
+func (index *Index) tag(e entry) Tag {
+	return Tag{Name: e.name, File: index.files[e.file], Line: int(e.line), Kind: index.kinds[e.kind]}
+}
+
+// Replace returns a new index in which the tags of the files for which
+// replaced returns true are substituted with tags
+func (index *Index) Replace(replaced func(file string) bool, tags []Tag) *Index {
+	builder := newBuilder()
+	kept := make([]entry, 0, len(index.entries))
+	for _, e := range index.entries {
+		if !replaced(index.files[e.file]) {
+			kept = append(kept, builder.entry(index.tag(e)))
+		}
+	}
+	added := make([]entry, 0, len(tags))
+	for _, tag := range tags {
+		added = append(added, builder.entry(tag))
+	}
+	sortEntries(added)
+	// merge the two sorted sequences
+	entries := make([]entry, 0, len(kept)+len(added))
+	i, j := 0, 0
+	for i < len(kept) || j < len(added) {
+		if j == len(added) || (i < len(kept) && kept[i].name <= added[j].name) {
+			entries = append(entries, kept[i])
+			i++
+		} else {
+			entries = append(entries, added[j])
+			j++
+		}
+	}
+	return builder.build(entries)
+}
+
+func sortEntries(entries []entry) {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+}
+
+// builder interns the file names and kinds of the entries of an index
+type builder struct {
+	files     []string
+	fileIDs   map[string]int32
+	kinds     []string
+	kindIDs   map[string]int32
+	nameBytes int64
+}
+
+func newBuilder() *builder {
+	return &builder{
+		fileIDs: make(map[string]int32),
+		kindIDs: make(map[string]int32),
+		// the empty kind
+		kinds: []string{""},
+	}
+}
+
+func (b *builder) entry(tag Tag) entry {
+	file, ok := b.fileIDs[tag.File]
+	if !ok {
+		file = int32(len(b.files))
+		b.files = append(b.files, tag.File)
+		b.fileIDs[tag.File] = file
+	}
+	kind, ok := b.kindIDs[tag.Kind]
+	if !ok && tag.Kind != "" {
+		kind = int32(len(b.kinds))
+		b.kinds = append(b.kinds, tag.Kind)
+		b.kindIDs[tag.Kind] = kind
+	}
+	b.nameBytes += int64(len(tag.Name))
+	return entry{name: tag.Name, file: file, kind: kind, line: int32(tag.Line)}
+}
+
+func (b *builder) build(entries []entry) *Index {
+	folded := make([]int32, len(entries))
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		folded[i] = int32(i)
+		keys[i] = strings.ToLower(e.name)
+	}
+	sort.SliceStable(folded, func(i, j int) bool { return keys[folded[i]] < keys[folded[j]] })
+
+	memory := int64(cap(entries))*int64(unsafe.Sizeof(entry{})) +
+		int64(cap(folded))*4 + b.nameBytes
+	for _, names := range [][]string{b.files, b.kinds} {
+		for _, name := range names {
+			memory += int64(unsafe.Sizeof(name)) + int64(len(name))
+		}
+	}
+	return &Index{entries: entries, folded: folded, files: b.files, kinds: b.kinds, memory: memory}
+}
+
+type byScore struct {
+	tags   []Tag
+	scores []int
+}
+
+func (s byScore) Len() int           { return len(s.tags) }
+func (s byScore) Less(i, j int) bool { return s.scores[i] > s.scores[j] }
+func (s byScore) Swap(i, j int) {
+	s.tags[i], s.tags[j] = s.tags[j], s.tags[i]
+	s.scores[i], s.scores[j] = s.scores[j], s.scores[i]
+}
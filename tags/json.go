@@ -0,0 +1,53 @@
+package tags
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// jsonTag is a line of the json output of universal ctags
+// (--output-format=json); pseudo tags have a _type of "ptag"
+type jsonTag struct {
+	Type    string `json:"_type"`
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+	Line    int    `json:"line"`
+	Kind    string `json:"kind"`
+}
+
+// ParseJSONFile reads the tags of a universal ctags json file
+func ParseJSONFile(path string) ([]Tag, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseJSON(f)
+}
+
+// ParseJSON reads the tags of a universal ctags json stream (a json
+// object per line)
+func ParseJSON(r io.Reader) ([]Tag, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	tags := []Tag{}
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var t jsonTag
+		if err := json.Unmarshal(line, &t); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		if t.Type != "tag" || t.Name == "" {
+			continue
+		}
+		tags = append(tags, Tag{Name: t.Name, File: t.Path, Line: t.Line, Kind: t.Kind, Pattern: t.Pattern})
+	}
+	return tags, scanner.Err()
+}
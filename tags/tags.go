@@ -0,0 +1,189 @@
+package tags
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Tag is a symbol definition found in a tag file
+type Tag struct {
+	Name string `json:"name"`
+	// as recorded in the tag file (usually relative to the project root)
+	File string `json:"file"`
+	// 0 if unknown
+	Line int `json:"line"`
+	// empty for etags files, which do not record kinds
+	Kind string `json:"kind,omitempty"`
+	// the search pattern of the definition (e.g. "/^func main() {$/"),
+	// known only when writing tag files
+	Pattern string `json:"-"`
+}
+
+// ParseFile reads the tags of an etags or ctags file
+func ParseFile(path string) ([]Tag, error) {
+	return ParseFileFiltered(path, nil)
+}
+
+// ParseFileFiltered reads only the tags of the files for which include
+// returns true (all files if include is nil)
+func ParseFileFiltered(path string, include func(file string) bool) ([]Tag, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parse(f, include)
+}
+
+// Parse reads the tags of an etags or ctags (detected by the form feed
+// that starts etags sections) stream
+func Parse(r io.Reader) ([]Tag, error) {
+	return parse(r, nil)
+}
+
+func parse(r io.Reader, include func(string) bool) ([]Tag, error) {
+	if include == nil {
+		include = func(string) bool { return true }
+	}
+	reader := bufio.NewReaderSize(r, 64*1024)
+	first, err := reader.Peek(1)
+	if err == io.EOF {
+		return []Tag{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if first[0] == '\x0c' {
+		return parseEtags(reader, include)
+	}
+	return parseCtags(reader, include)
+}
+
+// etags sections start with a form feed line followed by "file,size"
+// and contain "pattern\x7fname\x01line,offset" entries (the name is
+// implicit in the pattern if \x01 is missing); "file,include" sections
+// reference other tag files and are skipped. The size (in bytes) of a
+// section allows skipping the sections of excluded files.
+func parseEtags(reader *bufio.Reader, include func(string) bool) ([]Tag, error) {
+	tags := []Tag{}
+	file := ""
+	header := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return tags, nil
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "\x0c" {
+			header = true
+			continue
+		}
+		if header {
+			header = false
+			file = ""
+			i := strings.LastIndex(line, ",")
+			if i <= 0 || line[i+1:] == "include" {
+				continue
+			}
+			if include(line[:i]) {
+				file = line[:i]
+			} else if size, err := strconv.Atoi(line[i+1:]); err == nil {
+				if _, err := reader.Discard(size); err != nil && err != io.EOF {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if file == "" {
+			continue
+		}
+		del := strings.IndexByte(line, '\x7f')
+		if del < 0 {
+			continue
+		}
+		pattern, rest := line[:del], line[del+1:]
+		name := ""
+		if soh := strings.IndexByte(rest, '\x01'); soh >= 0 {
+			name, rest = rest[:soh], rest[soh+1:]
+		} else {
+			name = implicitName(pattern)
+		}
+		if name == "" {
+			continue
+		}
+		number := 0
+		if comma := strings.IndexByte(rest, ','); comma > 0 {
+			number, _ = strconv.Atoi(rest[:comma])
+		}
+		tags = append(tags, Tag{Name: name, File: file, Line: number})
+	}
+}
+
+// implicitName returns the last identifier of an etags pattern
+func implicitName(pattern string) string {
+	pattern = strings.TrimRight(pattern, " \t(=;{:,")
+	start := len(pattern)
+	for start > 0 && isIdentifier(pattern[start-1]) {
+		start--
+	}
+	return pattern[start:]
+}
+
+func isIdentifier(c byte) bool {
+	return c == '_' || c == '$' || c == '?' || c == '!' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// ctags lines are "name\tfile\taddress;\"\tfields..." where the address
+// is a line number or a search pattern and the fields include the kind
+// (either as a single letter or as kind:name) and optionally line:n
+func parseCtags(reader *bufio.Reader, include func(string) bool) ([]Tag, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	tags := []Tag{}
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 || bytes.HasPrefix(line, []byte("!_")) {
+			continue
+		}
+		fields := strings.Split(string(line), "\t")
+		if len(fields) < 3 {
+			continue
+		}
+		if !include(fields[1]) {
+			continue
+		}
+		tag := Tag{Name: fields[0], File: fields[1]}
+		address := fields[2]
+		extensions := []string{}
+		// the address may contain tabs (within a search pattern)
+		for i := 3; i < len(fields); i++ {
+			if strings.HasSuffix(address, `;"`) {
+				extensions = fields[i:]
+				break
+			}
+			address += "\t" + fields[i]
+		}
+		address = strings.TrimSuffix(address, `;"`)
+		if number, err := strconv.Atoi(address); err == nil {
+			tag.Line = number
+		}
+		for _, extension := range extensions {
+			switch {
+			case strings.HasPrefix(extension, "kind:"):
+				tag.Kind = extension[len("kind:"):]
+			case strings.HasPrefix(extension, "line:"):
+				tag.Line, _ = strconv.Atoi(extension[len("line:"):])
+			case !strings.Contains(extension, ":"):
+				tag.Kind = extension
+			}
+		}
+		tags = append(tags, tag)
+	}
+	return tags, scanner.Err()
+}
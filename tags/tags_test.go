@@ -0,0 +1,99 @@
+package tags
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Parse_Etags(t *testing.T) {
+	contents := "\x0c\nfoo.go,60\nfunc Foo(\x7fFoo\x013,20\ntype Bar\x7f7,80\n" +
+		"\x0c\n/gems/baz.rb,30\n  def baz(\x7fbaz\x0112,100\n" +
+		"\x0c\n/other/TAGS,include\n"
+	tags, err := Parse(strings.NewReader(contents))
+	assert.Nil(t, err)
+	assert.Equal(t, []Tag{
+		{Name: "Foo", File: "foo.go", Line: 3},
+		{Name: "Bar", File: "foo.go", Line: 7},
+		{Name: "baz", File: "/gems/baz.rb", Line: 12},
+	}, tags)
+}
+
+func Test_Parse_Ctags(t *testing.T) {
+	contents := "!_TAG_FILE_FORMAT\t2\t/extended format/\n" +
+		"Foo\tfoo.go\t/^func Foo(\t) {$/;\"\tf\tline:3\n" +
+		"Bar\tfoo.go\t7;\"\tkind:type\n" +
+		"Baz\tbaz.go\t/^var Baz$/\n"
+	tags, err := Parse(strings.NewReader(contents))
+	assert.Nil(t, err)
+	assert.Equal(t, []Tag{
+		{Name: "Foo", File: "foo.go", Line: 3, Kind: "f"},
+		{Name: "Bar", File: "foo.go", Line: 7, Kind: "type"},
+		{Name: "Baz", File: "baz.go"},
+	}, tags)
+}
+
+func Test_Parse_EmptyFile(t *testing.T) {
+	tags, err := Parse(strings.NewReader(""))
+	assert.Nil(t, err)
+	assert.Empty(t, tags)
+}
+
+func Test_implicitName(t *testing.T) {
+	assert.Equal(t, "foo", implicitName("def foo("))
+	assert.Equal(t, "valid?", implicitName("  def valid?"))
+	assert.Equal(t, "", implicitName("("))
+}
+
+func Test_ParseJSON(t *testing.T) {
+	contents := `{"_type": "ptag", "name": "JSON_OUTPUT_VERSION", "path": "0.0", "pattern": "in development"}
+{"_type": "tag", "name": "Foo", "path": "foo.go", "pattern": "/^func Foo() {$/", "line": 3, "kind": "func"}
+
+{"_type": "tag", "name": "Bar", "path": "foo.go", "pattern": "/^type Bar struct {$/", "line": 7, "kind": "struct", "scope": "main"}
+`
+	tags, err := ParseJSON(strings.NewReader(contents))
+	assert.Nil(t, err)
+	assert.Equal(t, []Tag{
+		{Name: "Foo", File: "foo.go", Line: 3, Kind: "func", Pattern: "/^func Foo() {$/"},
+		{Name: "Bar", File: "foo.go", Line: 7, Kind: "struct", Pattern: "/^type Bar struct {$/"},
+	}, tags)
+
+	_, err = ParseJSON(strings.NewReader("{\"_type\": \"tag\"}\n{\n"))
+	assert.Equal(t, "line 2: unexpected end of JSON input", err.Error())
+}
+
+func Test_WriteEtags_And_WriteCtags_CanBeParsed(t *testing.T) {
+	tags := []Tag{
+		{Name: "Bar", File: "foo.go", Line: 7, Kind: "struct", Pattern: `/^type Bar struct { \/\/ a\/b$/`},
+		{Name: "Foo", File: "foo.go", Line: 3, Kind: "func", Pattern: "/^func Foo() {$/"},
+		{Name: "baz", File: "/gems/baz.rb", Line: 12, Kind: "method"},
+	}
+	var etags bytes.Buffer
+	assert.Nil(t, WriteEtags(&etags, tags))
+	assert.Contains(t, etags.String(), "type Bar\x7fBar\x017,\n")
+	parsed, err := Parse(&etags)
+	assert.Nil(t, err)
+	assert.Equal(t, []Tag{
+		{Name: "baz", File: "/gems/baz.rb", Line: 12},
+		{Name: "Foo", File: "foo.go", Line: 3},
+		{Name: "Bar", File: "foo.go", Line: 7},
+	}, parsed)
+
+	var ctags bytes.Buffer
+	assert.Nil(t, WriteCtags(&ctags, tags))
+	parsed, err = Parse(&ctags)
+	assert.Nil(t, err)
+	assert.Equal(t, []Tag{
+		{Name: "Bar", File: "foo.go", Line: 7, Kind: "struct"},
+		{Name: "Foo", File: "foo.go", Line: 3, Kind: "func"},
+		{Name: "baz", File: "/gems/baz.rb", Line: 12, Kind: "method"},
+	}, parsed)
+}
+
+func Test_patternText(t *testing.T) {
+	assert.Equal(t, "func Foo() {", patternText("/^func Foo() {$/"))
+	assert.Equal(t, `a/b\c`, patternText(`/^a\/b\\c$/`))
+	assert.Equal(t, "", patternText("12"))
+}
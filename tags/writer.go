@@ -0,0 +1,98 @@
+package tags
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteEtags writes tags in the etags format (see parseEtags); the
+// sections of the files are sorted by file name and their entries by
+// line. The character offsets of the definitions are not recorded.
+func WriteEtags(w io.Writer, tags []Tag) error {
+	files := map[string][]Tag{}
+	names := []string{}
+	for _, tag := range tags {
+		if _, ok := files[tag.File]; !ok {
+			names = append(names, tag.File)
+		}
+		files[tag.File] = append(files[tag.File], tag)
+	}
+	sort.Strings(names)
+	out := bufio.NewWriter(w)
+	for _, file := range names {
+		entries := files[file]
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Line < entries[j].Line })
+		var section bytes.Buffer
+		for _, tag := range entries {
+			section.WriteString(etagsText(tag))
+			section.WriteByte('\x7f')
+			section.WriteString(tag.Name)
+			section.WriteByte('\x01')
+			section.WriteString(strconv.Itoa(tag.Line))
+			section.WriteString(",\n")
+		}
+		fmt.Fprintf(out, "\x0c\n%s,%d\n", file, section.Len())
+		section.WriteTo(out)
+	}
+	return out.Flush()
+}
+
+// etagsText returns the beginning of the line of the definition up to
+// (and including) the name of the tag
+func etagsText(tag Tag) string {
+	text := patternText(tag.Pattern)
+	if i := strings.Index(text, tag.Name); i >= 0 {
+		return text[:i+len(tag.Name)]
+	}
+	if text == "" {
+		return tag.Name
+	}
+	return text
+}
+
+// patternText returns the text that a ctags search pattern (e.g.
+// "/^foo\/bar$/") matches
+func patternText(pattern string) string {
+	if len(pattern) < 2 || (pattern[0] != '/' && pattern[0] != '?') {
+		return ""
+	}
+	text := strings.TrimPrefix(pattern[1:len(pattern)-1], "^")
+	text = strings.TrimSuffix(text, "$")
+	replacer := strings.NewReplacer(`\\`, `\`, `\/`, `/`, `\?`, `?`)
+	return strings.Map(func(r rune) rune {
+		if r == '\x7f' || r == '\x01' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, replacer.Replace(text))
+}
+
+// WriteCtags writes tags in the (sorted) extended ctags format along
+// with their kind and line
+func WriteCtags(w io.Writer, tags []Tag) error {
+	sorted := append([]Tag{}, tags...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	out := bufio.NewWriter(w)
+	out.WriteString("!_TAG_FILE_FORMAT\t2\t/extended format/\n")
+	out.WriteString("!_TAG_FILE_SORTED\t1\t/0=unsorted, 1=sorted, 2=foldcase/\n")
+	for _, tag := range sorted {
+		address := tag.Pattern
+		if address == "" {
+			address = strconv.Itoa(tag.Line)
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s;\"", tag.Name, tag.File, address)
+		if tag.Kind != "" {
+			fmt.Fprintf(out, "\tkind:%s", tag.Kind)
+		}
+		if tag.Line > 0 {
+			fmt.Fprintf(out, "\tline:%d", tag.Line)
+		}
+		out.WriteString("\n")
+	}
+	return out.Flush()
+}
@@ -1,5 +1,7 @@
 package utils
 
+import "sort"
+
 type Set struct {
 	elements map[string]bool
 	// TODO: synchronize access using a mutex
@@ -32,3 +34,20 @@ func (s *Set) Remove(element string) {
 func (s *Set) Len() int {
 	return len(s.elements)
 }
+
+// AddAll adds all the elements of other to the set
+func (s *Set) AddAll(other *Set) {
+	for element := range other.elements {
+		s.elements[element] = true
+	}
+}
+
+// Elements returns the (sorted) elements of the set
+func (s *Set) Elements() []string {
+	elements := make([]string, 0, len(s.elements))
+	for element := range s.elements {
+		elements = append(elements, element)
+	}
+	sort.Strings(elements)
+	return elements
+}
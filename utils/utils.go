@@ -1,21 +1,31 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
+// Canonicalize expands a leading ~ (the home directory) of path
 func Canonicalize(path string) string {
-	if strings.Contains(path, "~") {
-		home := os.Getenv("HOME")
-		path = strings.Replace(path, "~", home, 1)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		path = os.Getenv("HOME") + path[1:]
 	}
 	return path
 }
 
+// ExpandPath also expands the environment variables ($VAR or ${VAR}) of
+// path; it is meant for the paths of config files only
+func ExpandPath(path string) string {
+	return Canonicalize(os.ExpandEnv(path))
+}
+
 func FileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil || !os.IsNotExist(err)
@@ -31,10 +41,48 @@ func IsDirectory(path string) (bool, error) {
 }
 
 func ExecInPath(cmd string, args []string, path string) ([]byte, error) {
-	command := exec.Command(cmd, args...)
+	return ExecInPathWithContext(context.Background(), cmd, args, path)
+}
+
+// same as ExecInPath but the process is killed when ctx is cancelled
+func ExecInPathWithContext(ctx context.Context, cmd string, args []string, path string) ([]byte, error) {
+	return ExecInPathWithInput(ctx, cmd, args, path, nil)
+}
+
+// same as ExecInPathWithContext but input (if not nil) is passed to the
+// standard input of the process
+func ExecInPathWithInput(ctx context.Context, cmd string, args []string, path string, input io.Reader) ([]byte, error) {
+	command := exec.CommandContext(ctx, cmd, args...)
 	command.Dir = path
+	command.Stdin = input
 	out, err := command.CombinedOutput()
-	return out, err
+	if err != nil {
+		execErr := &ExecError{Command: cmd, ExitCode: -1, Output: out, Err: err}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			execErr.ExitCode = exitErr.ExitCode()
+		}
+		return out, execErr
+	}
+	return out, nil
+}
+
+// ExecError is returned when a command fails to run or exits with a
+// non-zero status
+type ExecError struct {
+	Command string
+	// -1 if the command did not run or was killed
+	ExitCode int
+	// the combined stdout and stderr of the command
+	Output []byte
+	Err    error
+}
+
+func (e *ExecError) Error() string {
+	out := strings.TrimSpace(string(e.Output))
+	if out == "" {
+		return fmt.Sprintf("%s: %s", e.Command, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Command, e.Err, out)
 }
 
 func ConcatFiles(to string, files []string, path string) error {
@@ -42,11 +90,30 @@ func ConcatFiles(to string, files []string, path string) error {
 	if err != nil {
 		return errors.New(fmt.Sprint(string(out), err.Error()))
 	}
-	f, err := os.Create(to)
+	return WriteFileAtomic(to, out, 0644)
+}
+
+// WriteFileAtomic writes data to a temporary file in the same directory
+// and renames it to path, so that readers never observe a partial file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	f.Write(out)
-	return nil
+	defer os.Remove(f.Name()) // no-op after a successful rename
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
 }
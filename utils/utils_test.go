@@ -1,11 +1,13 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -35,12 +37,34 @@ func Test_Canonicalize(t *testing.T) {
 		{"~/foo/bar", fmt.Sprintf("%s/foo/bar", home)},
 		{"/foo/bar", "/foo/bar"},
 		{"", ""},
+		{"/foo/~/bar~", "/foo/~/bar~"},
+		{"~user/foo", "~user/foo"},
+		{"/foo/$HOME", "/foo/$HOME"},
 	}
 	for _, testCase := range testCases {
 		assert.Equal(t, testCase.expandedPath, Canonicalize(testCase.path))
 	}
 }
 
+func Test_ExpandPath(t *testing.T) {
+	home := os.Getenv("HOME")
+	assert.NotEmpty(t, home)
+	defer os.Unsetenv("TAGGER_TEST_DIR")
+	os.Setenv("TAGGER_TEST_DIR", "/tmp/tagger")
+	var testCases = []struct {
+		path         string
+		expandedPath string
+	}{
+		{"~/foo", fmt.Sprintf("%s/foo", home)},
+		{"$TAGGER_TEST_DIR/foo", "/tmp/tagger/foo"},
+		{"${TAGGER_TEST_DIR}.d/~", "/tmp/tagger.d/~"},
+		{"$HOME/foo", fmt.Sprintf("%s/foo", home)},
+	}
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expandedPath, ExpandPath(testCase.path))
+	}
+}
+
 func Test_IsDirectory_ReturnsTrue_WhenPathIsDirectory(t *testing.T) {
 	// create the project directory
 	path, err := ioutil.TempDir("", "tagger-tests")
@@ -89,3 +113,46 @@ func Test_ConcatFiles(t *testing.T) {
 	contents, err := ioutil.ReadFile(f_c)
 	assert.Equal(t, "aaabbb", string(contents))
 }
+
+func Test_ExecInPathWithContext_KillsProcess_OnCancellation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := ExecInPathWithContext(ctx, "sleep", []string{"5"}, os.TempDir())
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func Test_WriteFileAtomic(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	fname := filepath.Join(path, "state.yml")
+	assert.Nil(t, WriteFileAtomic(fname, []byte("foo"), 0600))
+	assert.Nil(t, WriteFileAtomic(fname, []byte("bar"), 0600))
+	contents, err := ioutil.ReadFile(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", string(contents))
+	info, err := os.Stat(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+}
+
+func Test_ExecInPath_ReturnsExecError_OnFailure(t *testing.T) {
+	_, err := ExecInPath("/bin/sh", []string{"-c", "echo oops >&2; exit 3"}, os.TempDir())
+	execErr, ok := err.(*ExecError)
+	assert.True(t, ok)
+	assert.Equal(t, 3, execErr.ExitCode)
+	assert.Equal(t, "oops\n", string(execErr.Output))
+	assert.Contains(t, execErr.Error(), "oops")
+
+	_, err = ExecInPath("/foo/bar", []string{}, os.TempDir())
+	execErr, ok = err.(*ExecError)
+	assert.True(t, ok)
+	assert.Equal(t, -1, execErr.ExitCode)
+}
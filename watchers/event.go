@@ -4,6 +4,12 @@ import "github.com/kkentzo/tagger/utils"
 
 type Event struct {
 	Names *utils.Set
+	// ignore any cached state and reindex everything (including the
+	// dependencies); the existing tag files are kept until replaced
+	Full bool
+	// reindex the project's dependencies (e.g. its gemset) even if they
+	// have not changed
+	Dependencies bool
 }
 
 func NewEvent() Event {
@@ -11,3 +17,17 @@ func NewEvent() Event {
 		Names: utils.NewSet([]string{}),
 	}
 }
+
+// Merge returns an event that combines the names and options of both
+// events
+func (event Event) Merge(other Event) Event {
+	merged := NewEvent()
+	for _, e := range []Event{event, other} {
+		if e.Names != nil {
+			merged.Names.AddAll(e.Names)
+		}
+		merged.Full = merged.Full || e.Full
+		merged.Dependencies = merged.Dependencies || e.Dependencies
+	}
+	return merged
+}
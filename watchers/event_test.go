@@ -0,0 +1,23 @@
+package watchers
+
+import (
+	"testing"
+
+	"github.com/kkentzo/tagger/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Event_Merge_CombinesNamesAndOptions(t *testing.T) {
+	event := Event{Names: utils.NewSet([]string{"a"}), Full: true}
+	other := Event{Names: utils.NewSet([]string{"b"}), Dependencies: true}
+
+	merged := event.Merge(other)
+	assert.True(t, merged.Names.Has("a"))
+	assert.True(t, merged.Names.Has("b"))
+	assert.True(t, merged.Full)
+	assert.True(t, merged.Dependencies)
+	assert.Equal(t, 1, event.Names.Len())
+
+	merged = Event{}.Merge(NewEvent())
+	assert.Equal(t, 0, merged.Names.Len())
+}
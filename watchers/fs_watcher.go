@@ -26,6 +26,8 @@ type FsWatcher struct {
 	*fsnotify.Watcher
 	exclusions    *utils.Set
 	tagFilePrefix string
+	// the watched directories
+	watched *utils.Set
 }
 
 func NewFsWatcher(exclusions []string, tagFilePrefix string) *FsWatcher {
@@ -37,6 +39,7 @@ func NewFsWatcher(exclusions []string, tagFilePrefix string) *FsWatcher {
 		Watcher:       w,
 		exclusions:    utils.NewSet(exclusions),
 		tagFilePrefix: tagFilePrefix,
+		watched:       utils.NewSet([]string{}),
 	}
 }
 
@@ -79,12 +82,35 @@ func (watcher *FsWatcher) Add(path string) error {
 		err := watcher.Watcher.Add(file)
 		if err != nil {
 			log.Error(err.Error())
+		} else if !watcher.watched.Has(file) {
+			watcher.watched.Add(file)
+			fsWatches.Add(1)
 		}
 		log.Debug("Adding", file)
 	}
 	return nil
 }
 
+// Remove stops watching path; the watches of its subdirectories are
+// considered gone too, since fsnotify drops them along with deleted
+// directories
+func (watcher *FsWatcher) Remove(path string) error {
+	err := watcher.Watcher.Remove(path)
+	for _, dir := range watcher.watched.Elements() {
+		if dir == path || strings.HasPrefix(dir, path+string(filepath.Separator)) {
+			watcher.watched.Remove(dir)
+			fsWatches.Add(-1)
+		}
+	}
+	return err
+}
+
+func (watcher *FsWatcher) Close() error {
+	fsWatches.Add(-float64(watcher.watched.Len()))
+	watcher.watched = utils.NewSet([]string{})
+	return watcher.Watcher.Close()
+}
+
 func (watcher *FsWatcher) Events() chan fsnotify.Event {
 	return watcher.Watcher.Events
 }
@@ -93,6 +119,12 @@ func (watcher *FsWatcher) Errors() chan error {
 	return watcher.Watcher.Errors
 }
 
+// Discover returns the directories under root that are watched given
+// the exclusions (names or absolute paths)
+func Discover(root string, exclusions []string) ([]string, error) {
+	return discover(root, utils.NewSet(exclusions))
+}
+
 // return a slice with all directories under root but the excluded ones
 func discover(root string, exclusions *utils.Set) ([]string, error) {
 	var directories []string
@@ -102,7 +134,8 @@ func discover(root string, exclusions *utils.Set) ([]string, error) {
 				return err
 			}
 			if info.IsDir() {
-				if exclusions.Has(info.Name()) {
+				// exclusions are either names or absolute paths
+				if exclusions.Has(info.Name()) || exclusions.Has(path) {
 					return filepath.SkipDir
 				} else {
 					directories = append(directories, path)
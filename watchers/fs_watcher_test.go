@@ -324,3 +324,41 @@ func Test_discover_DoesNotIncludeExcludedDirectories(t *testing.T) {
 	assert.Contains(t, dirs, path)
 	assert.Contains(t, dirs, dirName)
 }
+
+func Test_discover_DoesNotIncludeExcludedPaths(t *testing.T) {
+	// create the project directory
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	// create two directories with the same name; only one is excluded
+	excluded := filepath.Join(path, "dirA", "sub")
+	err = os.MkdirAll(excluded, os.ModePerm)
+	assert.Nil(t, err)
+	included := filepath.Join(path, "dirB", "sub")
+	err = os.MkdirAll(included, os.ModePerm)
+	assert.Nil(t, err)
+
+	dirs, err := discover(path, utils.NewSet([]string{excluded}))
+	assert.Nil(t, err)
+	assert.Contains(t, dirs, included)
+	assert.NotContains(t, dirs, excluded)
+}
+
+func Test_FsWatcher_TracksWatchedDirectories(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	sub := filepath.Join(path, "sub")
+	assert.Nil(t, os.MkdirAll(filepath.Join(sub, "subsub"), 0755))
+
+	watcher := NewFsWatcher([]string{}, "TAGS")
+	assert.Nil(t, watcher.Add(path))
+	assert.Nil(t, watcher.Add(path))
+	assert.Equal(t, 3, watcher.watched.Len())
+
+	watcher.Remove(sub)
+	assert.Equal(t, []string{path}, watcher.watched.Elements())
+	watcher.Close()
+	assert.Equal(t, 0, watcher.watched.Len())
+}
@@ -0,0 +1,14 @@
+package watchers
+
+import "github.com/kkentzo/tagger/metrics"
+
+var (
+	fsEvents = metrics.NewCounter("tagger_fs_events_total",
+		"Filesystem events received", "project")
+	fsEventsFiltered = metrics.NewCounter("tagger_fs_events_filtered_total",
+		"Filesystem events that did not require reindexing", "project")
+	reindexBatches = metrics.NewCounter("tagger_reindex_batches_total",
+		"Batches of changes emitted for reindexing", "project")
+	fsWatches = metrics.NewGauge("tagger_fs_watches",
+		"Active filesystem watches")
+)
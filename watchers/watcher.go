@@ -60,14 +60,23 @@ func (watcher *Watcher) Watch(ctx context.Context) {
 			return
 		case <-ticker.C:
 			if mustReindex {
-				watcher.events <- event
+				// the consumer may have stopped listening
+				select {
+				case watcher.events <- event:
+				case <-ctx.Done():
+					return
+				}
+				reindexBatches.Inc(watcher.Root)
 				mustReindex = false
 				event = NewEvent()
 			}
 		case fsEvent := <-watcher.fsWatcher.Events():
+			fsEvents.Inc(watcher.Root)
 			shouldReindex := watcher.fsWatcher.Handle(fsEvent)
 			if shouldReindex {
 				event.Names.Add(fsEvent.Name)
+			} else {
+				fsEventsFiltered.Inc(watcher.Root)
 			}
 			mustReindex = mustReindex || shouldReindex
 		case err := <-watcher.fsWatcher.Errors():
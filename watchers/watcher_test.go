@@ -86,3 +86,27 @@ func Test_Watcher_Watch_ShouldReindex_WhenTickerTicks(t *testing.T) {
 	// expectation
 	assert.IsType(t, Event{}, <-watcher.events)
 }
+
+func Test_Watcher_Watch_ShouldReturn_OnCancellation_WhileEventIsPending(t *testing.T) {
+	fsWatcher := &MockFsWatcher{}
+	events := make(chan fsnotify.Event)
+	fsWatcher.On("Events").Return(events)
+	fsWatcher.On("Errors").Return(make(chan error))
+	fsWatcher.On("Add", "foo").Return(nil)
+	fsWatcher.On("Handle", mock.AnythingOfType("fsnotify.Event")).Return(true)
+
+	watcher := NewWatcher("foo", []string{}, "TAGS", 10*time.Millisecond)
+	watcher.fsWatcher = fsWatcher
+
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		watcher.Watch(ctx)
+		close(returned)
+	}()
+	// nobody consumes the resulting event
+	events <- fsnotify.Event{Name: "foo", Op: fsnotify.Create}
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-returned
+}
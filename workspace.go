@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kkentzo/tagger/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// how long to wait for a burst of workspace events to settle
+var WorkspaceSettlePeriod = time.Second
+
+var DefaultMarkers = []string{".git"}
+
+// Workspace is a set of project directories matching a glob pattern
+// (e.g. ~/Workspace/*) that contain at least one of the marker files
+type Workspace struct {
+	Glob    string
+	Markers []string
+}
+
+// Root returns the part of the glob that precedes the first pattern
+// component; this is the directory that needs to be watched
+func (workspace *Workspace) Root() string {
+	root := ""
+	for _, component := range strings.Split(utils.Canonicalize(workspace.Glob), string(filepath.Separator)) {
+		if hasMeta(component) {
+			break
+		}
+		root = root + component + string(filepath.Separator)
+	}
+	return filepath.Clean(root)
+}
+
+// Discover returns the (sorted) projects of the workspace along with the
+// directories that need to be watched for detecting project changes
+func (workspace *Workspace) Discover() (projects []string, directories []string) {
+	root := workspace.Root()
+	if isDir, err := utils.IsDirectory(root); err != nil || !isDir {
+		log.Debugf("Workspace root %s does not exist", root)
+		return
+	}
+	markers := workspace.Markers
+	if len(markers) == 0 {
+		markers = DefaultMarkers
+	}
+	pattern, _ := filepath.Rel(root, utils.Canonicalize(workspace.Glob))
+	// expand the pattern one component at a time so that the intermediate
+	// directories can also be watched
+	candidates := []string{root}
+	for _, component := range strings.Split(pattern, string(filepath.Separator)) {
+		directories = append(directories, candidates...)
+		next := []string{}
+		for _, dir := range candidates {
+			matches, err := filepath.Glob(filepath.Join(dir, component))
+			if err != nil {
+				log.Errorf("Invalid workspace glob %s: %s", workspace.Glob, err)
+				return nil, nil
+			}
+			for _, match := range matches {
+				if isDir, err := utils.IsDirectory(match); err == nil && isDir {
+					next = append(next, match)
+				}
+			}
+		}
+		candidates = next
+	}
+	// the candidates are watched too in order to detect marker changes
+	directories = append(directories, candidates...)
+	for _, dir := range candidates {
+		for _, marker := range markers {
+			if utils.FileExists(filepath.Join(dir, marker)) {
+				projects = append(projects, dir)
+				break
+			}
+		}
+	}
+	sort.Strings(projects)
+	return projects, directories
+}
+
+func hasMeta(component string) bool {
+	return strings.ContainsAny(component, `*?[\`)
+}
+
+// WorkspaceWatcher keeps the manager's discovered projects in sync with
+// the contents of the workspaces
+type WorkspaceWatcher struct {
+	Workspaces []Workspace
+	Manager    *Manager
+}
+
+// Watch performs an initial discovery and then rediscovers projects on
+// every change under the workspaces until ctx is cancelled
+func (watcher *WorkspaceWatcher) Watch(ctx context.Context) {
+	if len(watcher.Workspaces) == 0 {
+		watcher.Manager.SetDiscovered([]string{})
+		return
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error("Unable to watch workspaces: ", err)
+		return
+	}
+	defer w.Close()
+	watched := utils.NewSet([]string{})
+	var dirs []string
+	scan := func() {
+		projects := []string{}
+		directories := []string{}
+		for _, workspace := range watcher.Workspaces {
+			p, d := workspace.Discover()
+			projects = append(projects, p...)
+			directories = append(directories, d...)
+		}
+		watcher.Manager.SetDiscovered(projects)
+		// update the watched directories
+		current := utils.NewSet(directories)
+		for _, dir := range dirs {
+			if !current.Has(dir) && watched.Has(dir) {
+				w.Remove(dir)
+				watched.Remove(dir)
+			}
+		}
+		for _, dir := range directories {
+			if !watched.Has(dir) {
+				if err := w.Add(dir); err != nil {
+					log.Error(err.Error())
+					continue
+				}
+				watched.Add(dir)
+			}
+		}
+		dirs = directories
+	}
+	scan()
+
+	settle := time.NewTimer(WorkspaceSettlePeriod)
+	settle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-w.Events:
+			if e.Op&fsnotify.Chmod == fsnotify.Chmod || e.Op&fsnotify.Write == fsnotify.Write {
+				continue
+			}
+			if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && watched.Has(e.Name) {
+				// fsnotify drops the watches of deleted directories
+				watched.Remove(e.Name)
+			}
+			settle.Reset(WorkspaceSettlePeriod)
+		case <-settle.C:
+			scan()
+		case err := <-w.Errors:
+			log.Error(err.Error())
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TouchFile(t *testing.T, fname string) *os.File {
+	f, err := os.Create(fname)
+	assert.Nil(t, err)
+	return f
+}
+
+func Mkdir(t *testing.T, path string) {
+	assert.Nil(t, os.MkdirAll(path, os.ModePerm))
+}
+
+// WaitFor polls condition until it holds or the test times out
+func WaitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_Workspace_Root(t *testing.T) {
+	var testCases = []struct {
+		glob string
+		root string
+	}{
+		{"/foo/*", "/foo"},
+		{"/foo/*/bar/*", "/foo"},
+		{"/foo/ba?", "/foo"},
+		{"/foo/bar", "/foo/bar"},
+		{"~/foo/*", filepath.Join(os.Getenv("HOME"), "foo")},
+	}
+	for _, testCase := range testCases {
+		workspace := &Workspace{Glob: testCase.glob}
+		assert.Equal(t, testCase.root, workspace.Root())
+	}
+}
+
+func Test_Workspace_Discover_ReturnsDirectoriesWithMarkers(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	Mkdir(t, filepath.Join(path, "a", ".git"))
+	Mkdir(t, filepath.Join(path, "b"))
+	TouchFile(t, filepath.Join(path, "b", "go.mod")).Close()
+	Mkdir(t, filepath.Join(path, "c"))
+	TouchFile(t, filepath.Join(path, "d")).Close()
+
+	workspace := &Workspace{Glob: filepath.Join(path, "*")}
+	projects, directories := workspace.Discover()
+	assert.Equal(t, []string{filepath.Join(path, "a")}, projects)
+	assert.Contains(t, directories, path)
+	assert.Contains(t, directories, filepath.Join(path, "c"))
+
+	workspace.Markers = []string{".git", "go.mod"}
+	projects, _ = workspace.Discover()
+	assert.Equal(t, []string{filepath.Join(path, "a"), filepath.Join(path, "b")}, projects)
+}
+
+func Test_Workspace_Discover_WatchesIntermediateDirectories(t *testing.T) {
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	Mkdir(t, filepath.Join(path, "org", "a", ".git"))
+	workspace := &Workspace{Glob: filepath.Join(path, "*", "*")}
+	projects, directories := workspace.Discover()
+	assert.Equal(t, []string{filepath.Join(path, "org", "a")}, projects)
+	assert.Equal(t,
+		[]string{path, filepath.Join(path, "org"), filepath.Join(path, "org", "a")},
+		directories)
+}
+
+func Test_Workspace_Discover_ReturnsNothing_WhenRootDoesNotExist(t *testing.T) {
+	workspace := &Workspace{Glob: "/foo/bar/*"}
+	projects, directories := workspace.Discover()
+	assert.Empty(t, projects)
+	assert.Empty(t, directories)
+}
+
+func Test_WorkspaceWatcher_Watch_AddsAndRemovesProjects(t *testing.T) {
+	defer func(d time.Duration) { WorkspaceSettlePeriod = d }(WorkspaceSettlePeriod)
+	WorkspaceSettlePeriod = 10 * time.Millisecond
+
+	path, err := ioutil.TempDir("", "tagger-tests")
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+	project := filepath.Join(path, "a")
+	Mkdir(t, project)
+
+	watcher := CreateMockWatcher()
+	indexer := &MockIndexer{}
+	indexer.On("Create", project).Return(indexer)
+	indexer.On("CreateWatcher", project).Return(watcher)
+	indexer.On("Index", project, mock.AnythingOfType("watchers.Event"))
+	manager := NewManager(indexer, []struct{ Path string }{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ws := &WorkspaceWatcher{
+		Workspaces: []Workspace{{Glob: filepath.Join(path, "*")}},
+		Manager:    manager,
+	}
+	go ws.Watch(ctx)
+
+	Mkdir(t, filepath.Join(project, ".git"))
+	WaitFor(t, func() bool { return manager.Exists(project) })
+	os.RemoveAll(project)
+	WaitFor(t, func() bool { return !manager.Exists(project) })
+}